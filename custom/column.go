@@ -0,0 +1,573 @@
+package custom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sc4023/data"
+)
+
+// every column file starts with a magic and format version, followed by the blocks of the column, each block is
+// framed as its payload length (uint32), the payload, and the CRC32 of the payload (uint32), the payload of a block of
+// a compressed column is the compressed encoded block if compression shrinks it, which the top bit of its payload
+// length is set for, blocks whose encoded payload is larger than MaxInflatedBlockSize are never compressed so readers
+// inflate blocks into a buffer of that size
+const (
+	ColumnFileMagic      = "SCCF"
+	ColumnFileVersion    = 3
+	ColumnFileHeaderSize = int64(len(ColumnFileMagic) + 2)
+	MaxInflatedBlockSize = 64 << 10
+
+	compressedBlockFlag = uint32(1) << 31
+)
+
+// error of readers of column files whose content doesn't match what was written, e.g. because of a truncated write
+// or a column file of another format version, Block is -1 if the column file header is corrupted
+type CorruptionError struct {
+	Column string
+	Block  int
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	if e.Block == -1 {
+		return fmt.Sprintf("column %s is corrupted: %s", e.Column, e.Reason)
+	}
+	return fmt.Sprintf("column %s is corrupted at block %d: %s", e.Column, e.Block, e.Reason)
+}
+
+// writer of column files, every WriteFrom call writes one block
+type ColumnWriter struct {
+	*baseWriter
+	writer     *bufio.Writer
+	column     *data.Metadata // column of the blocks, the encoding of every block is recorded in its block encodings
+	compressed *boundedBuffer // compressed payload of the last block, only set if the column is compressed
+}
+
+// buffer that holds at most limit bytes, writes past the limit are dropped and mark the buffer as full
+type boundedBuffer struct {
+	buf   []byte
+	limit int
+	full  bool
+}
+
+// state of the block being read from a column file
+type blockFrame struct {
+	column     string
+	block      int            // index of the block being read
+	open       bool           // whether a block is being read, i.e. its payload length is read but its CRC32 is not
+	payloadEnd int64          // byte offset of the end of the payload of the block, of the inflated payload if compressed
+	blockEnd   int64          // byte offset of the end of the block, after its CRC32
+	src        *bufio.Reader  // reader of the frames
+	crc        hash.Hash32    // CRC32 of the payload read so far
+	hashing    *hashingReader // reader of the payload of uncompressed blocks, computes the CRC32 of the payload
+	inflating  bool           // whether the block being read is compressed, its values are read from payload then
+	compressed []byte         // compressed payload of the block being read, only set if the column is compressed
+	inflated   []byte         // inflated payload of the block being read, one byte larger than MaxInflatedBlockSize
+	payload    *bytes.Reader  // reader of the inflated payload
+}
+
+// reader that computes the CRC32 of the payload while values are read
+type hashingReader struct {
+	src *bufio.Reader
+	crc hash.Hash32
+}
+
+// init writer of a column file of the column, the header is written when the file is empty
+func NewColumnWriter(filePath string, limitedSlice LimitedSlice, column *data.Metadata) (Writer, error) {
+	bw, err := newBaseWriter(filePath, limitedSlice)
+	if err != nil {
+		return nil, err
+	}
+	w := &ColumnWriter{baseWriter: bw, writer: bufio.NewWriter(bw.file), column: column}
+	if column.Compression != "" {
+		w.compressed = &boundedBuffer{buf: make([]byte, 0, MaxInflatedBlockSize)}
+	}
+	if w.GetByteOffset() == 0 {
+		w.writer.WriteString(ColumnFileMagic)
+		binary.Write(w.writer, binary.LittleEndian, uint16(ColumnFileVersion))
+		if err := w.writer.Flush(); err != nil {
+			return nil, fmt.Errorf("failed to write header of column %s: %w", column.Name, err)
+		}
+	}
+	return w, nil
+}
+
+// write data from start to end as the last block of the column, its indexes are computed before it is written, the
+// block is encoded in the smallest of the encodings the column allows, which is recorded in the block encodings of the
+// column, and compressed if the column is compressed and compression shrinks it, empty blocks are not written
+func (w ColumnWriter) WriteFrom(start int, end int) error {
+	if end < start {
+		return nil
+	}
+
+	// every candidate is encoded once to count its bytes and the smallest is encoded again to write it, so the limited
+	// slice is the only buffer, ties go to the candidate that comes first as it is cheaper to decode
+	encoding, size := data.BlockPlain, -1
+	for _, candidate := range w.column.BlockEncodingCandidates() {
+		counter := &byteCounter{}
+		if err := w.encode(counter, candidate, start, end); err != nil {
+			return err
+		}
+		if size == -1 || counter.n < size {
+			encoding, size = candidate, counter.n
+		}
+	}
+	w.column.BlockEncodings[w.column.NumBlocks-1] = encoding
+
+	compressed := false
+	if w.compressed != nil && size <= MaxInflatedBlockSize {
+		var err error
+		if compressed, err = w.compress(encoding, start, end, size-1); err != nil {
+			return err
+		}
+	}
+	crc := crc32.NewIEEE()
+	if compressed {
+		if err := binary.Write(w.writer, binary.LittleEndian, uint32(len(w.compressed.buf))|compressedBlockFlag); err != nil {
+			return fmt.Errorf("failed to write block length: %w", err)
+		}
+		if _, err := w.writer.Write(w.compressed.buf); err != nil {
+			return fmt.Errorf("failed to write compressed block: %w", err)
+		}
+		crc.Write(w.compressed.buf)
+		w.column.CompressedBlocks += 1
+	} else {
+		if err := binary.Write(w.writer, binary.LittleEndian, uint32(size)); err != nil {
+			return fmt.Errorf("failed to write block length: %w", err)
+		}
+		if err := w.encode(io.MultiWriter(w.writer, crc), encoding, start, end); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(w.writer, binary.LittleEndian, crc.Sum32()); err != nil {
+		return fmt.Errorf("failed to write block checksum: %w", err)
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush writer: %w", err)
+	}
+	return nil
+}
+
+// encode the payload of the last block of the column
+func (w ColumnWriter) encode(dst io.Writer, encoding data.BlockEncoding, start int, end int) error {
+	switch encoding {
+	case data.BlockRunLength:
+		return encodeRunLength(dst, w.limitedSlice, start, end, func(i int, value any) error { return writeValue(dst, i, value) })
+	case data.BlockBitPacked:
+		return encodeBitPacked(dst, w.limitedSlice, start, end)
+	case data.BlockDelta:
+		return encodeDelta(dst, w.limitedSlice, start, end, w.column.BlockMin(w.column.NumBlocks-1).(int))
+	case data.BlockFrameOfReference:
+		return encodeFrameOfReference(dst, w.limitedSlice, start, end, w.column.BlockMin(w.column.NumBlocks-1).(float64))
+	case data.BlockDictionary:
+		return encodeBlockDictionary(dst, w.limitedSlice, start, end)
+	default:
+		return encodeFrom(dst, w.limitedSlice, start, end)
+	}
+}
+
+// compress the encoded last block of the column into the compressed buffer with the compression of the column, returns
+// false if the compressed block is larger than limit bytes
+func (w ColumnWriter) compress(encoding data.BlockEncoding, start int, end int, limit int) (bool, error) {
+	*w.compressed = boundedBuffer{buf: w.compressed.buf[:0], limit: limit}
+	var compressor io.WriteCloser
+	switch w.column.Compression {
+	case data.CompressionFlate:
+		compressor, _ = flate.NewWriter(w.compressed, flate.BestCompression) // only fails for invalid levels
+	case data.CompressionLZW:
+		compressor = lzw.NewWriter(w.compressed, lzw.LSB, 8)
+	default:
+		return false, nil
+	}
+	if err := w.encode(compressor, encoding, start, end); err != nil {
+		return false, err
+	}
+	if err := compressor.Close(); err != nil {
+		return false, fmt.Errorf("failed to compress block: %w", err)
+	}
+	return !w.compressed.full, nil
+}
+
+// init reader of a column file from the block at byte offset to byte limit, the column decides the type and encoding
+// of the data, the header of the column file is checked first
+func NewColumnReader(filePath string, column *data.Metadata, blockIdx int, offset int64, limit int64, limitedSlice LimitedSlice) (Reader, error) {
+	if err := checkColumnFileHeader(filePath, column.Name); err != nil {
+		return nil, err
+	}
+	reader := NewReader(filePath, offset, limit, limitedSlice, BinaryReaderType(column.Type))
+	if reader == nil {
+		return nil, fmt.Errorf("failed to open column %s", column.Name)
+	}
+	switch r := reader.(type) {
+	case *BinaryReader[int8]:
+		r.initColumn(column, blockIdx)
+	case *BinaryReader[int16]:
+		r.initColumn(column, blockIdx)
+	case *BinaryReader[int32]:
+		r.initColumn(column, blockIdx)
+	case *BinaryReader[float64]:
+		r.initColumn(column, blockIdx)
+	case *BinaryReader[string]:
+		r.initColumn(column, blockIdx)
+	}
+	return reader, nil
+}
+
+// byte offsets of the blocks of a column file in file order, found from the payload lengths of the blocks, for columns
+// without offset map
+func BlockOffsets(filePath string, column string) ([]int64, error) {
+	if err := checkColumnFileHeader(filePath, column); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open column %s: %w", column, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open column %s: %w", column, err)
+	}
+
+	offsets := []int64{}
+	for offset := ColumnFileHeaderSize; offset < info.Size(); {
+		var size uint32
+		if err := binary.Read(io.NewSectionReader(file, offset, 4), binary.LittleEndian, &size); err != nil {
+			return nil, &CorruptionError{Column: column, Block: len(offsets), Reason: "truncated block header"}
+		}
+		offsets = append(offsets, offset)
+		offset += 4 + int64(size&^compressedBlockFlag) + 4
+	}
+	return offsets, nil
+}
+
+// check magic and format version of a column file
+func checkColumnFileHeader(filePath string, column string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open column %s: %w", column, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, ColumnFileHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return &CorruptionError{Column: column, Block: -1, Reason: "missing column file header"}
+	}
+	if string(header[:len(ColumnFileMagic)]) != ColumnFileMagic {
+		return &CorruptionError{Column: column, Block: -1, Reason: "not a column file"}
+	}
+	if version := binary.LittleEndian.Uint16(header[len(ColumnFileMagic):]); version != ColumnFileVersion {
+		return &CorruptionError{Column: column, Block: -1, Reason: fmt.Sprintf("format version %d, expected %d", version, ColumnFileVersion)}
+	}
+	return nil
+}
+
+// read values of the column through block frames
+func (r *BinaryReader[T]) initColumn(column *data.Metadata, blockIdx int) {
+	r.column = column
+	r.frame = &blockFrame{column: column.Name, block: blockIdx, src: r.reader.(*bufio.Reader), crc: crc32.NewIEEE()}
+	r.frame.hashing = &hashingReader{src: r.frame.src, crc: r.frame.crc}
+	r.reader = r.frame.hashing
+	if column.Compression != "" {
+		r.frame.compressed = make([]byte, MaxInflatedBlockSize)
+		r.frame.inflated = make([]byte, MaxInflatedBlockSize+1)
+		r.frame.payload = bytes.NewReader(nil)
+	}
+}
+
+// read the payload length of the next block and inflate it if it is compressed, returns false at the end of the file or
+// if the block is corrupted
+func (r *BinaryReader[T]) startBlock() bool {
+	var size uint32
+	if err := binary.Read(r.frame.src, binary.LittleEndian, &size); err == io.EOF {
+		return false
+	} else if err != nil {
+		r.err = r.corrupted("truncated block header")
+		return false
+	}
+	r.byteOffset += 4
+	r.frame.open = true
+	r.frame.inflating = size&compressedBlockFlag != 0
+	size &^= compressedBlockFlag
+	r.frame.payloadEnd = r.byteOffset + int64(size)
+	r.frame.blockEnd = r.frame.payloadEnd + 4
+	r.frame.crc.Reset()
+	r.reader = r.frame.hashing
+	if r.frame.inflating && !r.inflate(int(size)) {
+		return false
+	}
+	if r.frame.block >= len(r.column.BlockEncodings) {
+		r.err = r.corrupted("block not in the catalog")
+		return false
+	}
+	r.encoding = r.column.BlockEncodings[r.frame.block]
+	if r.encoding == data.BlockDelta {
+		r.prev = r.column.BlockMin(r.frame.block).(int)
+	}
+	return true
+}
+
+// read the compressed payload of size bytes of the block and check its CRC32 before it is inflated, so a corrupted
+// payload is never decompressed, the values of the block are then read from the inflated payload and the byte offset
+// counts its bytes until the block ends
+func (r *BinaryReader[T]) inflate(size int) bool {
+	if r.frame.compressed == nil {
+		r.err = r.corrupted("compressed block of a column without compression")
+		return false
+	}
+	if size > len(r.frame.compressed) {
+		r.err = r.corrupted(fmt.Sprintf("compressed block larger than %d bytes", MaxInflatedBlockSize))
+		return false
+	}
+	compressed := r.frame.compressed[:size]
+	if _, err := io.ReadFull(r.frame.hashing, compressed); err != nil {
+		r.err = r.corrupted("truncated block")
+		return false
+	}
+	if !r.checkBlock() {
+		return false
+	}
+
+	var decompressor io.ReadCloser
+	switch r.column.Compression {
+	case data.CompressionFlate:
+		decompressor = flate.NewReader(bytes.NewReader(compressed))
+	case data.CompressionLZW:
+		decompressor = lzw.NewReader(bytes.NewReader(compressed), lzw.LSB, 8)
+	default:
+		r.err = r.corrupted(fmt.Sprintf("unsupported compression %q", r.column.Compression))
+		return false
+	}
+	defer decompressor.Close()
+
+	// the inflate buffer is one byte larger than any block the writer compresses, so a full buffer means a corrupted block
+	n, err := io.ReadFull(decompressor, r.frame.inflated)
+	if n > MaxInflatedBlockSize {
+		r.err = r.corrupted(fmt.Sprintf("inflated block larger than %d bytes", MaxInflatedBlockSize))
+		return false
+	}
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		r.err = r.corrupted(fmt.Sprintf("invalid compressed payload: %s", err))
+		return false
+	}
+	r.frame.payload.Reset(r.frame.inflated[:n])
+	r.reader = r.frame.payload
+	r.frame.payloadEnd = r.byteOffset + int64(n)
+	return true
+}
+
+// read the CRC32 of the block that was just read and compare it to the CRC32 of its payload, the CRC32 of a compressed
+// block is read before it is inflated
+func (r *BinaryReader[T]) endBlock() bool {
+	if r.byteOffset != r.frame.payloadEnd {
+		r.err = r.corrupted("payload length mismatch")
+		return false
+	}
+	if !r.frame.inflating && !r.checkBlock() {
+		return false
+	}
+	r.byteOffset = r.frame.blockEnd
+	r.frame.open = false
+	r.frame.block += 1
+	return true
+}
+
+// read the CRC32 of the block and compare it to the CRC32 of its payload
+func (r *BinaryReader[T]) checkBlock() bool {
+	var crc uint32
+	if err := binary.Read(r.frame.src, binary.LittleEndian, &crc); err != nil {
+		r.err = r.corrupted("truncated block checksum")
+		return false
+	}
+	if crc != r.frame.crc.Sum32() {
+		r.err = r.corrupted("checksum mismatch")
+		return false
+	}
+	return true
+}
+
+// read the next dictionary code of a bit packed block, see encodeBitPacked
+func (r *BinaryReader[T]) readPacked() (any, error) {
+	if r.packed.left == 0 {
+		if err := r.startPacked(); err != nil {
+			return nil, err
+		}
+	}
+	code, err := r.readBits()
+	if err != nil {
+		return nil, err
+	}
+	return codeOf[T](int(code)), nil
+}
+
+// read the next dictionary code of a delta encoded block, the first code of the block is relative to the block minimum,
+// see encodeDelta
+func (r *BinaryReader[T]) readDelta() (any, error) {
+	diff, err := binary.ReadVarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	r.byteOffset += int64(varintLen(diff))
+	r.prev += int(diff)
+	return codeOf[T](r.prev), nil
+}
+
+// read the next value of a frame of reference encoded block, the offsets are relative to the block minimum scaled by
+// the decimals read at the start of the block, see encodeFrameOfReference
+func (r *BinaryReader[T]) readFrameOfReference() (any, error) {
+	if r.packed.left == 0 {
+		decimals, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		r.byteOffset += 1
+		if err := r.startPacked(); err != nil {
+			return nil, err
+		}
+		r.packed.decimals = int(int8(decimals))
+	}
+	decimals := r.packed.decimals
+	offset, err := r.readBits()
+	if err != nil {
+		return nil, err
+	}
+	if decimals < 0 {
+		return math.Float64frombits(offset), nil
+	}
+	scale := math.Pow10(decimals)
+	scaledBase := int64(math.Round(r.column.BlockMin(r.frame.block).(float64) * scale))
+	return float64(scaledBase+int64(offset)) / scale, nil
+}
+
+// read the next string of a block with a dictionary of its own, the dictionary is read at the start of the block, see
+// encodeBlockDictionary
+func (r *BinaryReader[T]) readBlockDictionary() (any, error) {
+	if r.packed.left == 0 {
+		count, err := binary.ReadUvarint(r.reader)
+		if err != nil {
+			return nil, err
+		}
+		r.byteOffset += int64(uvarintLen(count))
+		if count > uint64(r.frame.payloadEnd-r.byteOffset) {
+			return nil, fmt.Errorf("invalid block dictionary of %d strings", count)
+		}
+		r.blockDictionary = r.blockDictionary[:0]
+		for range count {
+			str, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			r.blockDictionary = append(r.blockDictionary, str.(string))
+		}
+		if err := r.startPacked(); err != nil {
+			return nil, err
+		}
+	}
+	idx, err := r.readBits()
+	if err != nil {
+		return nil, err
+	}
+	if idx >= uint64(len(r.blockDictionary)) {
+		return nil, fmt.Errorf("index %d out of the block dictionary of %d strings", idx, len(r.blockDictionary))
+	}
+	return r.blockDictionary[idx], nil
+}
+
+// read the number of bits per value and of values at the start of a bit packed block
+func (r *BinaryReader[T]) startPacked() error {
+	width, err := r.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return err
+	}
+	r.byteOffset += 1 + int64(uvarintLen(count))
+	if width > 64 || count == 0 {
+		return fmt.Errorf("invalid bit packed block of %d values of %d bits", count, width)
+	}
+	r.packed = bitUnpacker{width: int(width), left: int(count)}
+	return nil
+}
+
+// read the next value of a bit packed block, reading bytes as needed
+func (r *BinaryReader[T]) readBits() (uint64, error) {
+	packed := &r.packed
+	for packed.accBits < packed.width {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		r.byteOffset += 1
+		packed.acc |= uint64(b) << packed.accBits
+		packed.accBits += 8
+	}
+	value := packed.acc & (1<<packed.width - 1)
+	packed.acc >>= packed.width
+	packed.accBits -= packed.width
+	packed.left -= 1
+	if packed.left == 0 {
+		packed.acc, packed.accBits = 0, 0 // padding of the last byte
+	}
+	return value, nil
+}
+
+// convert a dictionary code to the code width T
+func codeOf[T any](code int) any {
+	switch any(*new(T)).(type) {
+	case int16:
+		return int16(code)
+	case int32:
+		return int32(code)
+	}
+	return int8(code)
+}
+
+func (r *BinaryReader[T]) corrupted(reason string) error {
+	return &CorruptionError{Column: r.frame.column, Block: r.frame.block, Reason: reason}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.src.Read(p)
+	h.crc.Write(p[:n])
+	return n, err
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.src.ReadByte()
+	if err == nil {
+		h.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if len(b.buf)+len(p) > b.limit {
+		b.full = true
+	}
+	if !b.full {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// writer that only counts the bytes written to it
+type byteCounter struct {
+	n int
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
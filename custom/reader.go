@@ -3,27 +3,31 @@ package custom
 import (
 	"bufio"
 	"encoding/binary"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
 	"sc4023/data"
+	"sc4023/utils"
+	"slices"
 )
 
 type ReaderType int
 
 const (
-	FromCsv ReaderType = iota
-	FromBinaryInt8
+	FromBinaryInt8 ReaderType = iota
+	FromBinaryInt16
+	FromBinaryInt32
 	FromBinaryFloat64
 	FromBinaryString
 )
 
-// common fields and methods of the custom readers
+// common fields and methods of the custom readers, Err returns the error that stopped ReadTo early if any
 type Reader interface {
 	ReadTo(start int, end int) int
 	GetByteOffset() int64
+	Err() error
 }
 
 type baseReader struct {
@@ -31,19 +35,56 @@ type baseReader struct {
 	byteLimit    int64
 	byteOffset   int64
 	limitedSlice LimitedSlice
+	err          error
 }
 
 // reader to read csv files
 type CsvReader struct {
 	*baseReader
-	reader *csv.Reader
-	rowNumber int
+	reader      *utils.CsvRecordReader
+	schema      *data.Schema
+	header      utils.CsvHeader // fields of the schema columns
+	startOffset int64           // byte offset the reader started at, the record reader counts its input offset from there
+	startLine   int             // number of lines before startOffset
+	rejects     *RejectWriter   // writer of the rejected rows, rejected rows are only counted if nil
+	rejected    int             // number of rejected rows
 }
 
-// reader to read binary data of various types
-type BinaryReader[T string | float64 | int8] struct {
+// reader to read the binary rows written by RowWriter, byte offsets are exact as every row is length prefixed
+type RowReader struct {
 	*baseReader
 	reader *bufio.Reader
+	schema *data.Schema
+	buf    []byte // encoded row, reused for every row
+}
+
+// reader to read binary data of various types, run length encoded data is read as utils.Run for runs and
+// as single values for literals
+type BinaryReader[T string | float64 | int8 | int16 | int32] struct {
+	*baseReader
+	reader          valueReader
+	encoding        data.BlockEncoding // encoding of the block being read, data of other files is plain
+	literalsLeft    int                // number of literals left in the current literal group of run length encoded data
+	packed          bitUnpacker        // state of the bit packed block being read
+	prev            int                // previous code of the delta encoded block being read
+	blockDictionary []string           // distinct strings of the block being read if it has a dictionary of its own
+	column          *data.Metadata     // column of the column file, its block encodings and zone map are read from it
+	frame           *blockFrame        // block being read, only set for column files
+}
+
+// values of a bit packed block left to read and the bits read ahead of them
+type bitUnpacker struct {
+	width    int    // bits per value
+	left     int    // values of the block left to read
+	acc      uint64 // bits read but not returned yet, the lowest bits come first
+	accBits  int    // number of bits in acc
+	decimals int    // decimals the offsets of a frame of reference encoded block are scaled by
+}
+
+// source of binary values, a bufio.Reader or a hashingReader for column files
+type valueReader interface {
+	io.Reader
+	io.ByteReader
 }
 
 func newBaseReader(filePath string, offset int64, limit int64, limitedSlice LimitedSlice) (*baseReader, error) {
@@ -71,7 +112,64 @@ func newBaseReader(filePath string, offset int64, limit int64, limitedSlice Limi
 	return br, nil
 }
 
-// init new reader depending on type, includes byte offset to read from middle of file and byte limit which when reached
+// init new csv reader, records are read in the dialect of the schema, their fields picked according to the header and
+// parsed according to the schema, includes byte offset to read from middle of file and byte limit which when reached by
+// the file descriptor stops the reader from reading more data, gzipped csvs are decompressed and offset and limit are
+// counted in the decompressed data
+func NewCsvReader(filePath string, offset int64, limit int64, limitedSlice LimitedSlice, schema *data.Schema, header utils.CsvHeader) *CsvReader {
+	br, err := newBaseReader(filePath, 0, limit, limitedSlice)
+	if err != nil {
+		return nil
+	}
+	input, err := utils.CsvInput(br.file, filePath, offset)
+	if err != nil {
+		fmt.Printf("Error seeking to position: %s\n", err)
+		return nil
+	}
+	br.byteOffset = offset
+
+	return &CsvReader{
+		reader:      utils.NewCsvRecordReader(input, schema.Dialect),
+		baseReader:  br,
+		schema:      schema,
+		header:      header,
+		startOffset: offset,
+	}
+}
+
+// init new csv reader reading a raw csv from an input which can only be read once, like standard input, the header is
+// read from the input first and the rest of the input is read, name is the name of the input in errors
+func NewStreamCsvReader(input io.Reader, name string, limitedSlice LimitedSlice, schema *data.Schema) (*CsvReader, error) {
+	reader := utils.NewCsvRecordReader(input, schema.Dialect)
+	header, err := utils.ReadCsvHeaderFrom(reader, name, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CsvReader{
+		reader:     reader,
+		baseReader: &baseReader{byteLimit: -1, byteOffset: header.DataOffset, limitedSlice: limitedSlice},
+		schema:     schema,
+		header:     header,
+	}, nil
+}
+
+// init new binary row reader, rows are decoded according to the schema, includes byte offset to read from middle of
+// file and byte limit which when reached by the file descriptor stops the reader from reading more data
+func NewRowReader(filePath string, offset int64, limit int64, limitedSlice LimitedSlice, schema *data.Schema) Reader {
+	br, err := newBaseReader(filePath, offset, limit, limitedSlice)
+	if err != nil {
+		return nil
+	}
+
+	return &RowReader{
+		reader:     bufio.NewReader(br.file),
+		baseReader: br,
+		schema:     schema,
+	}
+}
+
+// init new binary reader depending on type, includes byte offset to read from middle of file and byte limit which when reached
 // by the file descriptor stops the reader from reading mroe data
 func NewReader(filePath string, offset int64, limit int64, limitedSlice LimitedSlice, readerType ReaderType) Reader {
 	br, err := newBaseReader(filePath, offset, limit, limitedSlice)
@@ -81,18 +179,24 @@ func NewReader(filePath string, offset int64, limit int64, limitedSlice LimitedS
 
 	var reader Reader
 	switch readerType {
-	case FromCsv:
-		csvReader := csv.NewReader(br.file)
-		reader = &CsvReader{
-			reader:     csvReader,
-			baseReader: br,
-		}
 	case FromBinaryInt8:
 		binaryReader := bufio.NewReader(br.file)
 		reader = &BinaryReader[int8]{
 			reader:     binaryReader,
 			baseReader: br,
 		}
+	case FromBinaryInt16:
+		binaryReader := bufio.NewReader(br.file)
+		reader = &BinaryReader[int16]{
+			reader:     binaryReader,
+			baseReader: br,
+		}
+	case FromBinaryInt32:
+		binaryReader := bufio.NewReader(br.file)
+		reader = &BinaryReader[int32]{
+			reader:     binaryReader,
+			baseReader: br,
+		}
 	case FromBinaryFloat64:
 		binaryReader := bufio.NewReader(br.file)
 		reader = &BinaryReader[float64]{
@@ -112,75 +216,210 @@ func NewReader(filePath string, offset int64, limit int64, limitedSlice LimitedS
 	return reader
 }
 
+// get binary reader type of a column type, -1 if the type is not supported
+func BinaryReaderType(colType any) ReaderType {
+	switch colType.(type) {
+	case int8:
+		return FromBinaryInt8
+	case int16:
+		return FromBinaryInt16
+	case int32:
+		return FromBinaryInt32
+	case float64:
+		return FromBinaryFloat64
+	case string:
+		return FromBinaryString
+	}
+	return -1
+}
+
 // loads data from disk and reads to the limited slice, stops when either
 // user defined ByteLimit or file EOF is reached, returns numebr of data read
 func (r *CsvReader) ReadTo(start int, end int) int {
 	readCnt := 0
 	for i := start; i <= end; i++ {
-		row, err := r.reader.Read()
-		r.rowNumber += 1
-		r.byteOffset += countCsvBytes(row)
+		if r.err != nil || (r.byteLimit != -1 && r.byteOffset >= r.byteLimit) {
+			break
+		}
+		record, err := r.reader.Read()
+		r.byteOffset = r.startOffset + r.reader.InputOffset()
 		if err == io.EOF {
 			break
 		}
-		data, err := data.ParseRow(row, r.rowNumber)
-		if err != nil {
-			r.byteOffset -= countCsvBytes(row)
+		rowNumber := r.startLine + r.reader.Line()
+		var recordErr *utils.CsvRecordError
+		if errors.As(err, &recordErr) {
+			r.err = r.reject(rowNumber, &data.RowError{Reason: data.RejectMalformed, Detail: recordErr.Err}, nil)
 			i -= 1
 			continue
-		}
-		r.limitedSlice.Set(i, data)
-		readCnt += 1
-		if r.byteLimit != -1 && r.byteOffset >= r.byteLimit {
+		} else if err != nil {
+			r.err = err
 			break
 		}
+		row, err := r.pickFields(record)
+		if err == nil {
+			var csvData data.CsvData
+			if csvData, err = data.ParseRow(row, rowNumber, r.schema); err == nil {
+				r.limitedSlice.Set(i, csvData)
+				readCnt += 1
+				continue
+			}
+		}
+		r.err = r.reject(rowNumber, err.(*data.RowError), record)
+		i -= 1
 	}
 	return readCnt
 }
 
+// write the rejected rows to rejects, startLine is the number of lines of the raw csv before the byte offset the reader
+// started at, so the rejected rows are written with their line in the raw csv
+func (r *CsvReader) Quarantine(rejects *RejectWriter, startLine int) {
+	r.rejects = rejects
+	r.startLine = startLine
+}
+
+// number of rows rejected so far
+func (r *CsvReader) Rejected() int {
+	return r.rejected
+}
+
+// count a rejected row and write it to rejects, a row that can't be written aborts the load
+func (r *CsvReader) reject(line int, rowErr *data.RowError, record []string) error {
+	r.rejected += 1
+	if r.rejects != nil {
+		return r.rejects.Write(line, rowErr, record)
+	}
+	return nil
+}
+
+// fields of the record in the order of the schema columns
+func (r *CsvReader) pickFields(record []string) ([]string, error) {
+	if r.header.Fields == nil {
+		return record, nil
+	}
+	if len(record) != r.header.NumFields {
+		return nil, &data.RowError{Reason: data.RejectFieldCount, Detail: fmt.Sprintf("expected %d fields per row, got %d", r.header.NumFields, len(record))}
+	}
+	row := make([]string, len(r.header.Fields))
+	for i, field := range r.header.Fields {
+		row[i] = record[field]
+	}
+	return row, nil
+}
+
 // get offset of current file descriptor
 func (r *CsvReader) GetByteOffset() int64 {
 	return r.byteOffset
 }
 
+// loads rows from disk and reads to the limited slice, stops when either user defined ByteLimit or file EOF is reached,
+// returns number of rows read
+func (r *RowReader) ReadTo(start int, end int) int {
+	readCnt := 0
+	for i := start; i <= end; i++ {
+		if r.err != nil || (r.byteLimit != -1 && r.byteOffset >= r.byteLimit) {
+			break
+		}
+		// the end of the file before the byte limit means the rows after it were lost
+		length, err := binary.ReadUvarint(r.reader)
+		if err == io.EOF && r.byteLimit == -1 {
+			break
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			r.buf = slices.Grow(r.buf[:0], int(length))[:length]
+			_, err = io.ReadFull(r.reader, r.buf)
+		}
+		var csvData data.CsvData
+		if err == nil {
+			csvData, err = data.DecodeRow(r.buf, r.schema)
+		}
+		if err != nil {
+			fmt.Printf("ReadTo: failed to read row at byte %d: %s\n", r.byteOffset, err)
+			r.err = err
+			break
+		}
+		r.byteOffset += int64(uvarintLen(length)) + int64(length)
+		r.limitedSlice.Set(i, csvData)
+		readCnt += 1
+	}
+	return readCnt
+}
+
+// get offset of current file descriptor
+func (r *RowReader) GetByteOffset() int64 {
+	return r.byteOffset
+}
+
 // loads data from disk (type is based on generic T type paraemter) and reads to the limited slice,
 //  stops when either user defined ByteLimit or file EOF is reached, returns numebr of data read
 func (r *BinaryReader[T]) ReadTo(start int, end int) int {
 	readCnt := 0
 	for i := start; i <= end; i++ {
-		var val any
-		var err error
+		// the byte offset in an inflated block counts the inflated bytes, so the byte limit is checked between blocks
+		if r.err != nil || (r.byteLimit != -1 && r.byteOffset >= r.byteLimit && (r.frame == nil || !r.frame.open)) {
+			break
+		}
 
-		switch any(*new(T)).(type) {
-		case int8:
-			var b byte
-			b, err = r.reader.ReadByte()
-			val = int8(b)
-			r.byteOffset += 1
-		case float64:
-			var f float64
-			err = binary.Read(r.reader, binary.LittleEndian, &f)
-			val = f
-			r.byteOffset += 8
-		case string:
-			var strBytes []byte
-			strBytes, err = r.reader.ReadBytes('\n')
-			if err == nil {
-				val = string(strBytes[:len(strBytes)-1])
-				r.byteOffset += int64(len(strBytes) + 1)
+		// column files are read block by block
+		if r.frame != nil && !r.frame.open && !r.startBlock() {
+			break
+		}
+
+		// run length encoded data starts a new group when the previous literal group is done
+		runLength := 0
+		if (r.encoding == data.BlockRunLength || r.encoding == data.BlockDelta) && r.literalsLeft == 0 {
+			header, err := binary.ReadUvarint(r.reader)
+			if err == io.EOF && r.frame == nil {
+				break
+			}
+			if err != nil {
+				r.fail(i, err)
+				break
+			}
+			r.byteOffset += int64(uvarintLen(header))
+			if header&1 == 1 {
+				runLength = int(header >> 1)
+			} else {
+				r.literalsLeft = int(header >> 1)
 			}
-		default:
-			fmt.Printf("ReadTo: unsupported type at index %d\n", i)
-			continue
 		}
 
-		if err == io.EOF {
+		var val any
+		var err error
+		switch r.encoding {
+		case data.BlockBitPacked:
+			val, err = r.readPacked()
+		case data.BlockDelta:
+			val, err = r.readDelta()
+		case data.BlockFrameOfReference:
+			val, err = r.readFrameOfReference()
+		case data.BlockDictionary:
+			val, err = r.readBlockDictionary()
+		default:
+			val, err = r.readValue()
+		}
+		if err == io.EOF && r.frame == nil {
+			break
+		}
+		if err != nil {
+			r.fail(i, err)
 			break
 		}
 
-		r.limitedSlice.Set(i, val)
+		if runLength > 0 {
+			r.limitedSlice.Set(i, utils.Run{Value: val, Length: runLength})
+		} else {
+			if r.literalsLeft > 0 {
+				r.literalsLeft -= 1
+			}
+			r.limitedSlice.Set(i, val)
+		}
 		readCnt += 1
-		if r.byteLimit != -1 && r.byteOffset >= r.byteLimit {
+
+		// check the block once all of its payload is read, the last bytes of a bit packed block can hold several values
+		if r.frame != nil && r.packed.left == 0 && r.byteOffset >= r.frame.payloadEnd && !r.endBlock() {
 			break
 		}
 	}
@@ -188,17 +427,78 @@ func (r *BinaryReader[T]) ReadTo(start int, end int) int {
 	return readCnt
 }
 
+// record error of a failed read at index i, data of column files always ends at block boundaries
+func (r *BinaryReader[T]) fail(i int, err error) {
+	if r.frame != nil {
+		r.err = r.corrupted("truncated block")
+		return
+	}
+	fmt.Printf("ReadTo: failed to read value at index %d: %s\n", i, err)
+	r.err = err
+}
+
+// read a single value of type T
+func (r *BinaryReader[T]) readValue() (any, error) {
+	var val any
+	var err error
+	switch any(*new(T)).(type) {
+	case int8:
+		var b byte
+		b, err = r.reader.ReadByte()
+		val = int8(b)
+		r.byteOffset += 1
+	case int16:
+		var n int16
+		err = binary.Read(r.reader, binary.LittleEndian, &n)
+		val = n
+		r.byteOffset += 2
+	case int32:
+		var n int32
+		err = binary.Read(r.reader, binary.LittleEndian, &n)
+		val = n
+		r.byteOffset += 4
+	case float64:
+		var f float64
+		err = binary.Read(r.reader, binary.LittleEndian, &f)
+		val = f
+		r.byteOffset += 8
+	case string:
+		var length uint64
+		if length, err = binary.ReadUvarint(r.reader); err != nil {
+			break
+		}
+		r.byteOffset += int64(uvarintLen(length))
+		if r.frame != nil && length > uint64(r.frame.payloadEnd-r.byteOffset) {
+			return nil, fmt.Errorf("string of %d bytes past the end of the block", length)
+		}
+		strBytes := make([]byte, length)
+		if _, err = io.ReadFull(r.reader, strBytes); err == nil {
+			val = string(strBytes)
+			r.byteOffset += int64(length)
+		}
+	}
+	return val, err
+}
+
 // get offset of current file descriptor
 func (r *BinaryReader[T]) GetByteOffset() int64 {
 	return r.byteOffset
 }
 
-// count byte width of one csv line
-func countCsvBytes(arr []string) int64 {
-	var res int64
-	for _, str := range arr {
-		res += int64(len(str))
-	}
-	res += 10 // 9 commas and 1 new line
-	return res
+// get error that stopped the reader
+func (b *baseReader) Err() error {
+	return b.err
+}
+
+// number of bytes of a varint
+func varintLen(x int64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutVarint(buf[:], x)
 }
+
+// number of bytes of a uvarint
+func uvarintLen(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
+
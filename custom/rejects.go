@@ -0,0 +1,90 @@
+package custom
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sc4023/data"
+	"strconv"
+)
+
+// header of the rejects csv, every rejected row has the raw csv it was read from, the line it starts on, the reason code
+// (one of the data.Reject reasons) with details, and the fields of the row from the fields column on, a malformed record
+// has no fields
+var RejectsHeader = []string{"source", "line", "reason", "detail", "fields"}
+
+// writer of the rows of a raw csv rejected while reading it, the rows are written without the header so the files of
+// several readers can be concatenated with AppendRejects
+type RejectWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	source string // path of the raw csv
+}
+
+// init new reject writer truncating filePath, source is the raw csv the rejected rows are read from
+func NewRejectWriter(filePath string, source string) (*RejectWriter, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rejects file %s: %w", filePath, err)
+	}
+	return &RejectWriter{file: file, writer: csv.NewWriter(file), source: source}, nil
+}
+
+// write a rejected row starting on line of the source
+func (w *RejectWriter) Write(line int, rowErr *data.RowError, fields []string) error {
+	row := append([]string{w.source, strconv.Itoa(line), rowErr.Reason, rowErr.Detail}, fields...)
+	if err := w.writer.Write(row); err != nil {
+		return fmt.Errorf("failed to write rejected row of line %d: %w", line, err)
+	}
+	return nil
+}
+
+// flush the rejected rows and close the file
+func (w *RejectWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// append the rejected rows of the files written by reject writers to the rejects csv at filePath in order, the header
+// is written if the rejects csv is new, the files are removed afterwards
+func AppendRejects(filePath string, files []string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open rejects file %s: %w", filePath, err)
+	}
+	defer out.Close()
+	if info, err := out.Stat(); err != nil {
+		return err
+	} else if info.Size() == 0 {
+		writer := csv.NewWriter(out)
+		writer.Write(RejectsHeader)
+		if writer.Flush(); writer.Error() != nil {
+			return writer.Error()
+		}
+	}
+
+	for _, path := range files {
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("failed to append %s to %s: %w", path, filePath, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
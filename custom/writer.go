@@ -5,6 +5,9 @@ import (
 	"encoding/binary"
 	"encoding/csv"
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -16,11 +19,13 @@ type WriterType int
 const (
 	ToCsv WriterType = iota
 	ToBinary
+	ToRows
 )
 
-// common methods and fields of CsvWriter and BinaryWriter
+// common methods and fields of CsvWriter, BinaryWriter, and RowWriter
 type Writer interface {
-	WriteFrom(start int, end int)
+	WriteFrom(start int, end int) error
+	GetByteOffset() int64
 }
 
 type baseWriter struct {
@@ -41,6 +46,14 @@ type BinaryWriter struct {
 	writer *bufio.Writer
 }
 
+// writer for the binary rows of the external sort runs, every row is a uvarint length followed by the row in the
+// binary row format of data.CsvData.AppendBinary
+type RowWriter struct {
+	*baseWriter
+	writer *bufio.Writer
+	buf    *[]byte // encoded row, reused for every row
+}
+
 func newBaseWriter(filePath string, limitedSlice LimitedSlice) (*baseWriter, error) {
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -83,6 +96,12 @@ func NewWriter(filePath string, limitedSlice LimitedSlice, writerType WriterType
 			baseWriter: bw,
 			writer:     binaryWriter,
 		}
+	case ToRows:
+		writer = &RowWriter{
+			baseWriter: bw,
+			writer:     bufio.NewWriter(bw.file),
+			buf:        new([]byte),
+		}
 	default:
 		fmt.Println("unknown writer type")
 	}
@@ -91,45 +110,279 @@ func NewWriter(filePath string, limitedSlice LimitedSlice, writerType WriterType
 }
 
 // write to csv file rows from start to end
-func (w CsvWriter) WriteFrom(start int, end int) {
+func (w CsvWriter) WriteFrom(start int, end int) error {
 	for i := start; i <= end; i++ {
 		csvData := w.limitedSlice.Get(i).(data.CsvData)
 		if err := w.writer.Write(csvData.ToRow()); err != nil {
-			fmt.Printf("failed to write data: %s\n", err)
+			return fmt.Errorf("failed to write data: %w", err)
 		}
 	}
 
 	w.writer.Flush()
 	if err := w.writer.Error(); err != nil {
-		fmt.Printf("failed to flush data: %s\n", err)
+		return fmt.Errorf("failed to flush data: %w", err)
 	}
+	return nil
 }
 
-// write to binary file data from start to end, automaticlaly detects
-// data type and writes appropriately to the binary file
-func (w BinaryWriter) WriteFrom(start int, end int) {
+// write to binary row file rows from start to end
+func (w RowWriter) WriteFrom(start int, end int) error {
 	for i := start; i <= end; i++ {
-		data := w.limitedSlice.Get(i)
-		switch d := data.(type) {
-		case int8:
-			if err := w.writer.WriteByte(byte(d)); err != nil {
-				fmt.Printf("failed to write int8 at %d: %v\n", i, err)
+		*w.buf = w.limitedSlice.Get(i).(data.CsvData).AppendBinary((*w.buf)[:0])
+		if err := writeUvarint(w.writer, i, uint64(len(*w.buf))); err != nil {
+			return err
+		}
+		if _, err := w.writer.Write(*w.buf); err != nil {
+			return fmt.Errorf("failed to write row at %d: %w", i, err)
+		}
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush writer: %w", err)
+	}
+	return nil
+}
+
+// write to binary file data from start to end, automaticlaly detects data type and writes appropriately to the
+// binary file
+func (w BinaryWriter) WriteFrom(start int, end int) error {
+	if err := encodeFrom(w.writer, w.limitedSlice, start, end); err != nil {
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush writer: %w", err)
+	}
+	return nil
+}
+
+// encode data of the limited slice from start to end to dst one value after the other
+func encodeFrom(dst io.Writer, limitedSlice LimitedSlice, start int, end int) error {
+	for i := start; i <= end; i++ {
+		if err := writeValue(dst, i, limitedSlice.Get(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode data of the limited slice from start to end to dst run length encoded, every value is written with write,
+// repeated values are grouped in runs and the other values in groups of literals, each group starts with a uvarint
+// header, odd headers are runs of header>>1 repeats of the value that follows, even headers are followed by header>>1
+// literal values
+func encodeRunLength(dst io.Writer, limitedSlice LimitedSlice, start int, end int, write func(i int, value any) error) error {
+	for i := start; i <= end; {
+		runEnd := i
+		for runEnd+1 <= end && limitedSlice.Get(runEnd+1) == limitedSlice.Get(i) {
+			runEnd += 1
+		}
+		if runEnd > i {
+			if err := writeUvarint(dst, i, uint64(runEnd-i+1)<<1|1); err != nil {
+				return err
 			}
-		case float64:
-			if err := binary.Write(w.writer, binary.LittleEndian, d); err != nil {
-				fmt.Printf("failed to write float64 at %d: %v\n", i, err)
+			if err := write(i, limitedSlice.Get(i)); err != nil {
+				return err
 			}
-		case string:
-			str := d + "\n"
-			if _, err := w.writer.WriteString(str); err != nil {
-				fmt.Printf("failed to write string at %d: %v\n", i, err)
+			i = runEnd + 1
+			continue
+		}
+
+		// group single values until the next run
+		groupEnd := i
+		for groupEnd+1 <= end && (groupEnd+2 > end || limitedSlice.Get(groupEnd+1) != limitedSlice.Get(groupEnd+2)) {
+			groupEnd += 1
+		}
+		if err := writeUvarint(dst, i, uint64(groupEnd-i+1)<<1); err != nil {
+			return err
+		}
+		for ; i <= groupEnd; i++ {
+			if err := write(i, limitedSlice.Get(i)); err != nil {
+				return err
 			}
-		default:
-			fmt.Printf("WriteFrom: unsupported type at index %d: %T, %v\n", i, d, data)
 		}
 	}
+	return nil
+}
 
-	if err := w.writer.Flush(); err != nil {
-		fmt.Printf("failed to flush writer: %v\n", err)
+// encode the dictionary codes of the limited slice from start to end to dst bit packed, a byte with the number of bits
+// per code, enough for the largest code, and the uvarint number of codes are followed by the codes, that many bits
+// each, filling every byte from its lowest bit up, the last byte is padded with zeros
+func encodeBitPacked(dst io.Writer, limitedSlice LimitedSlice, start int, end int) error {
+	maxCode := 0
+	for i := start; i <= end; i++ {
+		maxCode = max(maxCode, data.CodeIdx(limitedSlice.Get(i)))
+	}
+	return packBits(dst, start, end, bits.Len(uint(maxCode)), func(i int) uint64 { return uint64(data.CodeIdx(limitedSlice.Get(i))) })
+}
+
+// encode the dictionary codes of the limited slice from start to end to dst run length encoded, see encodeRunLength,
+// with the value of every group written as the difference to the value of the previous group, the first to base, the
+// block minimum of the zone map, the differences are varints, so sorted columns mostly store differences of 0 and 1
+func encodeDelta(dst io.Writer, limitedSlice LimitedSlice, start int, end int, base int) error {
+	prev := base
+	return encodeRunLength(dst, limitedSlice, start, end, func(i int, value any) error {
+		code := data.CodeIdx(value)
+		err := writeVarint(dst, i, int64(code-prev))
+		prev = code
+		return err
+	})
+}
+
+// encode the strings of the limited slice from start to end to dst with a dictionary of the distinct strings of the
+// block, the uvarint number of distinct strings and the strings in the order they first appear are followed by the
+// index of every string bit packed, see encodeBitPacked
+func encodeBlockDictionary(dst io.Writer, limitedSlice LimitedSlice, start int, end int) error {
+	indexes := map[string]int{} // the distinct strings of a block are at most the block size
+	for i := start; i <= end; i++ {
+		if _, ok := indexes[limitedSlice.Get(i).(string)]; !ok {
+			indexes[limitedSlice.Get(i).(string)] = len(indexes)
+		}
+	}
+	if err := writeUvarint(dst, start, uint64(len(indexes))); err != nil {
+		return err
+	}
+	written := 0
+	for i := start; i <= end; i++ {
+		if indexes[limitedSlice.Get(i).(string)] == written {
+			if err := writeValue(dst, i, limitedSlice.Get(i)); err != nil {
+				return err
+			}
+			written += 1
+		}
+	}
+	return packBits(dst, start, end, bits.Len(uint(len(indexes)-1)), func(i int) uint64 { return uint64(indexes[limitedSlice.Get(i).(string)]) })
+}
+
+// most decimals frame of reference encoding scales offsets by, and bits of the largest offset, values needing more are
+// stored as their 64 bits
+const (
+	maxOffsetDecimals = 6
+	maxOffsetBits     = 56
+)
+
+// encode the float64 values of the limited slice from start to end to dst as offsets from base, the block minimum of
+// the zone map, values and base are scaled by 10^decimals to integers with as few decimals as keep every value exact and
+// the offsets are the differences of the integers, a byte with the decimals is followed by the offsets bit packed, see
+// encodeBitPacked, blocks with values that can't be scaled exactly have -1 decimals and the 64 bits of every value,
+// values below base are NULLs and stored as offset 0
+func encodeFrameOfReference(dst io.Writer, limitedSlice LimitedSlice, start int, end int, base float64) error {
+	for decimals := 0; decimals <= maxOffsetDecimals; decimals++ {
+		scale := math.Pow10(decimals)
+		scaledBase, exact := scaleExact(base, scale)
+		var maxOffset uint64
+		for i := start; i <= end && exact; i++ {
+			v := limitedSlice.Get(i).(float64)
+			if v < base {
+				continue
+			}
+			scaled, ok := scaleExact(v, scale)
+			exact = ok && scaled-scaledBase < 1<<maxOffsetBits
+			maxOffset = max(maxOffset, uint64(scaled-scaledBase))
+		}
+		if !exact {
+			continue
+		}
+		if err := writeValue(dst, start, int8(decimals)); err != nil {
+			return err
+		}
+		return packBits(dst, start, end, bits.Len64(maxOffset), func(i int) uint64 {
+			scaled, _ := scaleExact(max(limitedSlice.Get(i).(float64), base), scale)
+			return uint64(scaled - scaledBase)
+		})
+	}
+	if err := writeValue(dst, start, int8(-1)); err != nil {
+		return err
+	}
+	return packBits(dst, start, end, 64, func(i int) uint64 { return math.Float64bits(limitedSlice.Get(i).(float64)) })
+}
+
+// value multiplied by scale and rounded to an integer, and whether dividing the integer by scale gives back the value
+func scaleExact(v float64, scale float64) (int64, bool) {
+	scaled := math.Round(v * scale)
+	return int64(scaled), math.Abs(scaled) < 1<<53 && scaled/scale == v
+}
+
+// write the values from start to end bit packed with width bits each, see encodeBitPacked, width is at most
+// maxOffsetBits or 64
+func packBits(dst io.Writer, start int, end int, width int, value func(i int) uint64) error {
+	if err := writeValue(dst, start, int8(width)); err != nil {
+		return err
+	}
+	if err := writeUvarint(dst, start, uint64(end-start+1)); err != nil {
+		return err
+	}
+	var acc uint64 // bits not written yet, the lowest bits are written first
+	accBits := 0
+	for i := start; i <= end; i++ {
+		acc |= value(i) << accBits
+		accBits += width
+		for accBits >= 8 {
+			if err := writeValue(dst, i, int8(acc)); err != nil {
+				return err
+			}
+			acc >>= 8
+			accBits -= 8
+		}
+	}
+	if accBits > 0 {
+		return writeValue(dst, end, int8(acc))
+	}
+	return nil
+}
+
+// write a single value at index i of the limited slice
+func writeValue(dst io.Writer, i int, data any) error {
+	switch d := data.(type) {
+	case int8:
+		if _, err := dst.Write([]byte{byte(d)}); err != nil {
+			return fmt.Errorf("failed to write int8 at %d: %w", i, err)
+		}
+	case int16, int32:
+		if err := binary.Write(dst, binary.LittleEndian, d); err != nil {
+			return fmt.Errorf("failed to write %T at %d: %w", d, i, err)
+		}
+	case float64:
+		if err := binary.Write(dst, binary.LittleEndian, d); err != nil {
+			return fmt.Errorf("failed to write float64 at %d: %w", i, err)
+		}
+	case string:
+		// strings are prefixed with their uvarint length as they can hold line breaks
+		if err := writeUvarint(dst, i, uint64(len(d))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(dst, d); err != nil {
+			return fmt.Errorf("failed to write string at %d: %w", i, err)
+		}
+	default:
+		return fmt.Errorf("WriteFrom: unsupported type at index %d: %T, %v", i, d, data)
+	}
+	return nil
+}
+
+// write a run length encoding header at index i of the limited slice
+func writeUvarint(dst io.Writer, i int, x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	if _, err := dst.Write(buf[:n]); err != nil {
+		return fmt.Errorf("failed to write run length header at %d: %w", i, err)
+	}
+	return nil
+}
+
+// write a varint at index i of the limited slice
+func writeVarint(dst io.Writer, i int, x int64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	if _, err := dst.Write(buf[:n]); err != nil {
+		return fmt.Errorf("failed to write varint at %d: %w", i, err)
+	}
+	return nil
+}
+
+// get byte offset of the end of the written data, the data is flushed after every WriteFrom
+func (b *baseWriter) GetByteOffset() int64 {
+	offset, err := b.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		fmt.Printf("failed to get byte offset: %v\n", err)
+		return -1
 	}
+	return offset
 }
@@ -3,10 +3,11 @@ package data
 type Bitmap []bool
 
 // check if a block can be skipped (not loaded to memory) and if the block or part of it qualifies for further filtering
-func (bm Bitmap) Check(matchVal int8) (skippable bool, qualified bool) {
-	if bm[matchVal] {
+func (bm Bitmap) Check(matchVal int) (skippable bool, qualified bool) {
+	// bit maps of blocks written before the dictionary grew don't have bits of the new codes
+	if matchVal >= 0 && matchVal < len(bm) && bm[matchVal] {
 		for i, otherValExists := range bm {
-			if i != int(matchVal) && otherValExists {
+			if i != matchVal && otherValExists {
 				// matchVal exists and other vals are in the block, non skippable
 				return false, true
 			}
@@ -0,0 +1,50 @@
+package data
+
+// encoding of a data block, every block of a column is written in the smallest of the encodings the column allows
+type BlockEncoding uint8
+
+const (
+	BlockPlain            BlockEncoding = iota // values one after the other
+	BlockRunLength                             // runs of repeated values and groups of literals
+	BlockBitPacked                             // dictionary codes with as many bits as the largest code of the block
+	BlockDelta                                 // differences between the runs of dictionary codes
+	BlockFrameOfReference                      // float64 offsets from the block minimum, bit packed
+	BlockDictionary                            // strings as indexes into a dictionary of the distinct strings of the block
+)
+
+// name of the encoding, the schema encoding that allows it
+func (e BlockEncoding) String() string {
+	switch e {
+	case BlockRunLength:
+		return EncodingRunLength
+	case BlockBitPacked:
+		return EncodingBitPacked
+	case BlockDelta:
+		return EncodingDelta
+	case BlockFrameOfReference:
+		return EncodingFrameOfReference
+	case BlockDictionary:
+		return EncodingBlockDictionary
+	}
+	return "plain"
+}
+
+// encodings the blocks of the column can be written in, plain is always allowed
+func (m *Metadata) BlockEncodingCandidates() []BlockEncoding {
+	candidates := []BlockEncoding{BlockPlain}
+	for _, c := range []struct {
+		allowed  bool
+		encoding BlockEncoding
+	}{
+		{m.RunLengthEncode, BlockRunLength},
+		{m.BitPacked, BlockBitPacked},
+		{m.Delta, BlockDelta},
+		{m.FrameOfReference, BlockFrameOfReference},
+		{m.BlockDictionary, BlockDictionary},
+	} {
+		if c.allowed {
+			candidates = append(candidates, c.encoding)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// version of the on-disk catalog, bump whenever the layout of Metadata or the column files changes
+const CatalogVersion = 12
+
+// on-disk catalog of the column store, holds the schema the column store was initialized with, which appends parse
+// new rows with, and the metadata and indexes of every column, the dictionaries of dictionary encoded columns are
+// stored in sidecar files next to it as high cardinality columns have dictionaries much larger than the rest of the
+// catalog
+type Catalog struct {
+	Version int
+	Schema  *Schema
+	Columns Metadatas
+}
+
+// metadata without custom json methods, used to avoid infinite recursion when (un)marshalling
+type metadataJSON Metadata
+
+// json does not know the concrete type behind Type, so it is stored as a type name
+func (m *Metadata) MarshalJSON() ([]byte, error) {
+	typeName, err := typeToName(m.Type)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		*metadataJSON
+		Type string
+	}{(*metadataJSON)(m), typeName})
+}
+
+// restore Type from its type name
+func (m *Metadata) UnmarshalJSON(b []byte) error {
+	aux := struct {
+		*metadataJSON
+		Type string
+	}{metadataJSON: (*metadataJSON)(m)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	colType, err := nameToType(aux.Type)
+	if err != nil {
+		return err
+	}
+	m.Type = colType
+	return nil
+}
+
+// write the schema and column store metadata to the catalog file and the dictionaries to their sidecar files
+func SaveCatalog(path string, schema *Schema, ms Metadatas) error {
+	b, err := json.Marshal(Catalog{Version: CatalogVersion, Schema: schema, Columns: ms})
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog: %w", err)
+	}
+	for _, m := range ms {
+		if !m.DictionaryEncode {
+			continue
+		}
+		if err := m.Dictionary.Save(filepath.Join(filepath.Dir(path), m.DictionaryFile())); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("failed to write catalog %s: %w", path, err)
+	}
+	return nil
+}
+
+// load column store metadata from the catalog file, fails if the catalog was written by another version
+func LoadCatalog(path string) (Metadatas, error) {
+	catalog, err := ReadCatalog(path)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Columns, nil
+}
+
+// load the whole catalog file, fails if the catalog was written by another version
+func ReadCatalog(path string) (*Catalog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog %s: %w", path, err)
+	}
+	var catalog Catalog
+	if err := json.Unmarshal(b, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to decode catalog %s: %w", path, err)
+	}
+	if catalog.Version != CatalogVersion {
+		return nil, fmt.Errorf("catalog %s has version %d, expected %d", path, catalog.Version, CatalogVersion)
+	}
+	for _, m := range catalog.Columns {
+		if !m.DictionaryEncode {
+			continue
+		}
+		if m.Dictionary, err = LoadDictionary(filepath.Join(filepath.Dir(path), m.DictionaryFile())); err != nil {
+			return nil, err
+		}
+	}
+	return &catalog, nil
+}
+
+func typeToName(colType any) (string, error) {
+	switch colType.(type) {
+	case int8:
+		return "int8", nil
+	case int16:
+		return "int16", nil
+	case int32:
+		return "int32", nil
+	case float64:
+		return "float64", nil
+	case string:
+		return "string", nil
+	}
+	return "", fmt.Errorf("unsupported column type %T", colType)
+}
+
+func nameToType(name string) (any, error) {
+	switch name {
+	case "int8":
+		return int8(0), nil
+	case "int16":
+		return int16(0), nil
+	case "int32":
+		return int32(0), nil
+	case "float64":
+		return float64(0), nil
+	case "string":
+		return "", nil
+	}
+	return nil, fmt.Errorf("unsupported column type %s", name)
+}
@@ -1,60 +1,66 @@
 package data
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
 	"strconv"
 )
 
-type CsvData struct {
-	Month         string
-	Town          string
-	FlatType      string
-	Block         string
-	StreetName    string
-	StoreyRange   string
-	FloorArea     float64
-	FlatModel     string
-	LeaseCommence string
-	ResalePrice   float64
-}
+// csv row deserialized to Go types, each value is a string or float64 depending on the column type in the schema,
+// or Null for empty fields of nullable columns
+type CsvData []any
 
-// deserialize csv row to Go types, returns error on malformed data and ignores the row on subsequent processing
-func ParseRow(row []string, rowNumber int) (CsvData, error) {
-	if len(row) != 10 {
-		fmt.Printf("expected 10 columns per row, got %d in row %d, ignoring row...\n", len(row), rowNumber)
-		return CsvData{}, fmt.Errorf("expected 10 columns per row")
-	}
+// reasons rows are rejected for, written to the rejects csv
+const (
+	RejectMalformed     = "malformed_record" // the record isn't valid csv in the dialect of the schema
+	RejectFieldCount    = "field_count"      // the record doesn't have the fields of the schema or the header
+	RejectMissingValue  = "missing_value"    // a field of a column that isn't nullable is empty
+	RejectInvalidNumber = "invalid_number"   // a field of a float64 column isn't a number
+)
 
-	floorArea, err := strconv.ParseFloat(row[6], 64)
-	if err != nil || floorArea < 0 {
-		fmt.Printf("invalid floor area in row %d, ignoring row...\n", rowNumber)
-		return CsvData{}, fmt.Errorf("invalid floor area")
-	}
+// error of a rejected row, Reason is one of the reject reasons and Detail says what was wrong, e.g. the column
+type RowError struct {
+	Reason string
+	Detail string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Detail)
+}
 
-	price, err := strconv.ParseFloat(row[9], 64)
-	if err != nil || price < 0 {
-		fmt.Printf("invalid price in row %d, ignoring row...\n", rowNumber)
-		return CsvData{}, fmt.Errorf("invalid floor price")
+// deserialize csv row to Go types, returns a *RowError on malformed data and ignores the row on subsequent processing
+func ParseRow(row []string, rowNumber int, schema *Schema) (CsvData, error) {
+	if len(row) != len(schema.Columns) {
+		return nil, &RowError{RejectFieldCount, fmt.Sprintf("expected %d columns, got %d in row %d", len(schema.Columns), len(row), rowNumber)}
 	}
 
-	csvData := CsvData{
-		Month:         row[0],
-		Town:          row[1],
-		FlatType:      row[2],
-		Block:         row[3],
-		StreetName:    row[4],
-		StoreyRange:   row[5],
-		FloorArea:     floorArea,
-		FlatModel:     row[7],
-		LeaseCommence: row[8],
-		ResalePrice:   price,
+	csvData := make(CsvData, len(row))
+	for i, col := range schema.Columns {
+		if col.Nullable && row[i] == "" {
+			csvData[i] = Null
+			continue
+		}
+		switch col.Type {
+		case TypeFloat64:
+			if row[i] == "" {
+				return nil, &RowError{RejectMissingValue, col.Name}
+			}
+			// NaN is reserved for NULLs in the raw column files
+			f, err := strconv.ParseFloat(row[i], 64)
+			if err != nil || math.IsNaN(f) {
+				return nil, &RowError{RejectInvalidNumber, col.Name}
+			}
+			csvData[i] = f
+		default:
+			csvData[i] = row[i]
+		}
 	}
 
 	return csvData, nil
 }
 
-// format float to the original length in raw data, ensures that byte offsets
-// of reader of sorted_chunk.csv is the same as that of raw data
+// format float to the original length in raw data, so rows written back to a csv look like the raw data
 func formatFloat(f float64) string {
 	str := fmt.Sprintf("%.2f", f)
 	if str[len(str)-2] == '0' {
@@ -66,34 +72,74 @@ func formatFloat(f float64) string {
 	return str
 }
 
-// to string array for writing to another csv file
+// to string array for writing to another csv file, NULLs are written as empty fields
 func (d CsvData) ToRow() []string {
-	return []string{
-		d.Month,
-		d.Town,
-		d.FlatType,
-		d.Block,
-		d.StreetName,
-		d.StoreyRange,
-		formatFloat(d.FloorArea),
-		d.FlatModel,
-		d.LeaseCommence,
-		formatFloat(d.ResalePrice),
+	row := make([]string, len(d))
+	for i, v := range d {
+		switch v := v.(type) {
+		case float64:
+			row[i] = formatFloat(v)
+		case string:
+			row[i] = v
+		}
 	}
+	return row
 }
 
-// to individual column data types
-func (d CsvData) ToCols() []any {
-	return []any{
-		MonthToInt[d.Month],
-		TownToInt[d.Town],
-		FlatTypeToInt[d.FlatType],
-		d.Block,
-		d.StreetName,
-		StoreyRangeToInt[d.StoreyRange],
-		d.FloorArea,
-		FlatModelToInt[d.FlatModel],
-		LeaseCommenceToInt[d.LeaseCommence],
-		d.ResalePrice,
+// tags of the values of a binary row
+const (
+	rowNull byte = iota
+	rowString
+	rowFloat64
+)
+
+// append the row to buf in the binary row format of the sorted runs of the external sort, every value is a tag byte
+// followed by the uvarint length and bytes of a string or the 8 little endian bytes of a float64, NULLs are only a tag
+func (d CsvData) AppendBinary(buf []byte) []byte {
+	for _, v := range d {
+		switch v := v.(type) {
+		case string:
+			buf = append(buf, rowString)
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case float64:
+			buf = append(buf, rowFloat64)
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		default:
+			buf = append(buf, rowNull)
+		}
 	}
+	return buf
+}
+
+// decode a row in the binary row format, fails if the row is malformed or doesn't have the columns of the schema
+func DecodeRow(b []byte, schema *Schema) (CsvData, error) {
+	csvData := make(CsvData, 0, len(schema.Columns))
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+		switch tag {
+		case rowNull:
+			csvData = append(csvData, Null)
+		case rowString:
+			n, size := binary.Uvarint(b)
+			if size <= 0 || uint64(len(b)-size) < n {
+				return nil, fmt.Errorf("truncated string")
+			}
+			csvData = append(csvData, string(b[size:size+int(n)]))
+			b = b[size+int(n):]
+		case rowFloat64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated float64")
+			}
+			csvData = append(csvData, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			b = b[8:]
+		default:
+			return nil, fmt.Errorf("unknown value tag %d", tag)
+		}
+	}
+	if len(csvData) != len(schema.Columns) {
+		return nil, fmt.Errorf("expected %d columns per row, got %d", len(schema.Columns), len(csvData))
+	}
+	return csvData, nil
 }
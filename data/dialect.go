@@ -0,0 +1,77 @@
+package data
+
+import "fmt"
+
+// how the header of the raw csv maps its fields to the columns of the schema
+const (
+	HeaderPosition = "position" // one header line, fields are in the order of the schema columns
+	HeaderNames    = "names"    // one header line, fields are matched to the schema columns by name, other fields are ignored
+	HeaderNone     = "none"     // no header line, fields are in the order of the schema columns
+)
+
+// dialect of the raw csv, RFC 4180 with the delimiter, quote, and comment characters replaced, empty fields take the
+// defaults of a plain csv with a header
+type Dialect struct {
+	Delimiter string `json:"delimiter,omitempty"` // character between fields, "," if empty
+	Quote     string `json:"quote,omitempty"`     // character quoting fields, `"` if empty
+	Comment   string `json:"comment,omitempty"`   // lines starting with it are skipped, no comment lines if empty
+	Header    string `json:"header,omitempty"`    // HeaderPosition, HeaderNames, or HeaderNone, HeaderPosition if empty
+}
+
+// character between fields
+func (d Dialect) DelimiterByte() byte {
+	return dialectByte(d.Delimiter, ',')
+}
+
+// character quoting fields
+func (d Dialect) QuoteByte() byte {
+	return dialectByte(d.Quote, '"')
+}
+
+// character starting comment lines, 0 if there are no comment lines
+func (d Dialect) CommentByte() byte {
+	return dialectByte(d.Comment, 0)
+}
+
+// how the header maps fields to columns
+func (d Dialect) HeaderMode() string {
+	if d.Header == "" {
+		return HeaderPosition
+	}
+	return d.Header
+}
+
+func dialectByte(c string, def byte) byte {
+	if c == "" {
+		return def
+	}
+	return c[0]
+}
+
+// check that the dialect characters are single, distinct ascii characters which can't be confused with line breaks
+func (d Dialect) validate() error {
+	chars := map[byte]string{}
+	for _, c := range []struct{ name, value string }{{"delimiter", d.Delimiter}, {"quote", d.Quote}, {"comment", d.Comment}} {
+		if c.value == "" {
+			continue
+		}
+		if len(c.value) != 1 || c.value[0] >= 0x80 || c.value[0] == '\r' || c.value[0] == '\n' {
+			return fmt.Errorf("csv %s %q must be a single ascii character other than a line break", c.name, c.value)
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		value byte
+	}{{"delimiter", d.DelimiterByte()}, {"quote", d.QuoteByte()}, {"comment", d.CommentByte()}} {
+		if other, ok := chars[c.value]; ok && c.value != 0 {
+			return fmt.Errorf("csv %s and %s are both %q", other, c.name, c.value)
+		}
+		chars[c.value] = c.name
+	}
+	switch d.HeaderMode() {
+	case HeaderPosition, HeaderNames, HeaderNone:
+	default:
+		return fmt.Errorf("unsupported csv header %q, expected %s, %s, or %s", d.Header, HeaderPosition, HeaderNames, HeaderNone)
+	}
+	return nil
+}
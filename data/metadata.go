@@ -1,6 +1,11 @@
 package data
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
 
 type Metadatas []*Metadata
 
@@ -8,112 +13,268 @@ type Metadata struct {
 	Name                string             // name of column
 	Type                any                // data type
 	DataSizeByte        int64              // size of data type in bytes
-	Sorted              bool               // whether or not col is sorted
-	RunLengthEncode     bool               // whether or not col is run length encoded
+	Sorted              bool               // whether or not col is the first column of the sort key
+	DictionaryEncode    bool               // whether or not col is dictionary encoded
+	Dictionary          Dictionary         `json:"-"` // values of dictionary encoded col, built during initialization, stored in a sidecar file
+	RunLengthEncode     bool               // whether or not blocks of col can be run length encoded
+	BitPacked           bool               // whether or not blocks of the dictionary codes of col can be bit packed
+	Delta               bool               // whether or not blocks of the dictionary codes of col can be delta encoded
+	FrameOfReference    bool               // whether or not blocks of col can be stored as offsets from the block minimum
+	BlockDictionary     bool               // whether or not blocks of col can be stored with a dictionary of their own
+	Compression         string             // compression of the encoded blocks of col, flate, lzw, or none if empty
+	Nullable            bool               // whether or not col can hold NULL values
 	ZoneMapIndexInt8    []ZoneMap[int8]    // zone map for int8 cols
+	ZoneMapIndexInt16   []ZoneMap[int16]   // zone map for int16 cols
+	ZoneMapIndexInt32   []ZoneMap[int32]   // zone map for int32 cols
 	ZoneMapIndexFloat64 []ZoneMap[float64] // zone map for float64 cols
 	BitMapIndex         []Bitmap           // bit map for exact queries
 	OffsetMapIndex      []int64            // byte offsets of each data block
+	BlockEncodings      []BlockEncoding    // encoding of each data block, the smallest of the candidates when it was written
+	CompressedBlocks    int                // number of data blocks stored compressed, those compression shrinks
+	ValidityIndex       []Validity         // validity bit map of each data block of nullable cols
+	NumBlocks           int                // number of data blocks
+	Segments            []Segment          // column files the data blocks are stored in, in block order
 }
 
-// init column store metadata to be used by main Store and QueryRunner structs
-func InitColumnStoreMetadata() Metadatas {
-	return Metadatas{
-		{
-			Name:             "month",
-			Type:             int8(0),
-			DataSizeByte:     1,
-			Sorted:           true,
-			RunLengthEncode:  true,
-			ZoneMapIndexInt8: []ZoneMap[int8]{},
-			OffsetMapIndex:   []int64{},
-		},
-		{
-			Name:            "town",
-			Type:            int8(0),
-			DataSizeByte:    1,
-			RunLengthEncode: true,
-			BitMapIndex:     []Bitmap{},
-			OffsetMapIndex:  []int64{},
-		},
-		{
-			Name:            "flat_type",
-			Type:            int8(0),
-			DataSizeByte:    1,
-			RunLengthEncode: true,
-		},
-		{
-			Name:            "block",
-			Type:            "",
-			RunLengthEncode: true,
-		},
-		{
-			Name:            "street_name",
-			Type:            "",
-			RunLengthEncode: true,
-		},
-		{
-			Name:            "storey_range",
-			Type:            int8(0),
-			DataSizeByte:    1,
-			RunLengthEncode: true,
-		},
-		{
-			Name:                "floor_area_sqm",
-			Type:                float64(0),
-			DataSizeByte:        8,
-			RunLengthEncode:     true,
-			ZoneMapIndexFloat64: []ZoneMap[float64]{},
-			OffsetMapIndex:      []int64{},
-		},
-		{
-			Name:            "flat_model",
-			Type:            int8(0),
-			DataSizeByte:    1,
-			RunLengthEncode: true,
-		},
-		{
-			Name:            "lease_commence_date",
-			Type:            int8(0),
-			DataSizeByte:    1,
-			RunLengthEncode: true,
-		},
-		{
-			Name:                "resale_price",
-			Type:                float64(0),
-			DataSizeByte:        8,
-			RunLengthEncode:     true,
-			ZoneMapIndexFloat64: []ZoneMap[float64]{},
-			OffsetMapIndex:      []int64{},
-		},
-	}
-}
-
-// create indexes for new data block
-func (m *Metadata) InitBlockIndexes(blockSize int64) {
+// consecutive data blocks stored in the same column file, appends in sort order continue the rle_<column> file and
+// other appends are written to delta segments in files of their own, the blocks of every segment are sorted
+type Segment struct {
+	Block int    // index of the first block of the segment
+	File  string // name of the column file in the column store directory
+}
+
+// init column store metadata from the schema, to be used by main Store and QueryRunner structs
+func InitColumnStoreMetadata(schema *Schema) Metadatas {
+	metadatas := Metadatas{}
+	for _, col := range schema.Columns {
+		metadata := &Metadata{
+			Name:             col.Name,
+			Sorted:           len(schema.SortKey) > 0 && schema.SortKey[0] == col.Name,
+			DictionaryEncode: col.HasEncoding(EncodingDictionary),
+			RunLengthEncode:  col.HasEncoding(EncodingRunLength),
+			BitPacked:        col.HasEncoding(EncodingBitPacked),
+			Delta:            col.HasEncoding(EncodingDelta),
+			FrameOfReference: col.HasEncoding(EncodingFrameOfReference),
+			BlockDictionary:  col.HasEncoding(EncodingBlockDictionary),
+			Compression:      col.Compression,
+			Nullable:         col.Nullable,
+		}
+
+		// dictionary encoded columns are stored as their codes, which start as int8 and are
+		// widened once the dictionary is built and its cardinality is known
+		switch {
+		case metadata.DictionaryEncode:
+			metadata.Type = int8(0)
+			metadata.DataSizeByte = 1
+		case col.Type == TypeFloat64:
+			metadata.Type = float64(0)
+			metadata.DataSizeByte = 8
+		default:
+			metadata.Type = ""
+		}
+
+		// empty instead of nil indexes mark which indexes are computed
+		if col.HasIndex(IndexZoneMap) {
+			switch metadata.Type.(type) {
+			case int8:
+				metadata.ZoneMapIndexInt8 = []ZoneMap[int8]{}
+			case float64:
+				metadata.ZoneMapIndexFloat64 = []ZoneMap[float64]{}
+			}
+		}
+		if col.HasIndex(IndexBitMap) {
+			metadata.BitMapIndex = []Bitmap{}
+		}
+		// appends find the last row of the sort key columns through their offset maps
+		if col.HasIndex(IndexOffsetMap) || slices.Contains(schema.SortKey, col.Name) {
+			metadata.OffsetMapIndex = []int64{}
+		}
+		if col.Nullable {
+			metadata.ValidityIndex = []Validity{}
+		}
+		metadatas = append(metadatas, metadata)
+	}
+	return metadatas
+}
+
+// set dictionary of the column and pick the narrowest code width that fits its cardinality, codes are the non-negative
+// values of the signed code width, so int8 codes fit 128 values, int16 codes fit 32768 values, and int32 codes fit the
+// rest, filters use -1 for values that aren't in the dictionary
+func (m *Metadata) SetDictionary(dictionary Dictionary) {
+	m.Dictionary = dictionary
+	hasZoneMap := m.ZoneMapIndexInt8 != nil || m.ZoneMapIndexInt16 != nil || m.ZoneMapIndexInt32 != nil
+	m.ZoneMapIndexInt8, m.ZoneMapIndexInt16, m.ZoneMapIndexInt32 = nil, nil, nil
+	switch {
+	case len(dictionary) <= math.MaxInt8+1:
+		m.Type, m.DataSizeByte = int8(0), 1
+		if hasZoneMap {
+			m.ZoneMapIndexInt8 = []ZoneMap[int8]{}
+		}
+	case len(dictionary) <= math.MaxInt16+1:
+		m.Type, m.DataSizeByte = int16(0), 2
+		if hasZoneMap {
+			m.ZoneMapIndexInt16 = []ZoneMap[int16]{}
+		}
+	default:
+		m.Type, m.DataSizeByte = int32(0), 4
+		if hasZoneMap {
+			m.ZoneMapIndexInt32 = []ZoneMap[int32]{}
+		}
+	}
+}
+
+// add the distinct values of appended data to the dictionary of the column, a new dictionary picks its code width,
+// an existing one keeps the code width of the blocks already written unless the new values don't fit, then the column
+// gets the next code width with empty zone maps, returns the new code of every existing code if values were added before
+// existing ones or the code width changed, the blocks already written have to be written again then
+func (m *Metadata) AddDictionaryValues(values map[string]bool) ([]int, error) {
+	if len(m.Dictionary) == 0 {
+		dictionary, err := NewDictionary(values)
+		if err != nil {
+			return nil, err
+		}
+		m.SetDictionary(dictionary)
+		return nil, nil
+	}
+	dictionary, remap, err := m.Dictionary.Extend(values)
+	if err != nil {
+		return nil, err
+	}
+	limit := math.MaxInt32 + 1
+	switch m.Type.(type) {
+	case int8:
+		limit = math.MaxInt8 + 1
+	case int16:
+		limit = math.MaxInt16 + 1
+	}
+	if len(dictionary) > limit {
+		if remap == nil {
+			remap = make([]int, len(m.Dictionary))
+			for code := range remap {
+				remap[code] = code
+			}
+		}
+		m.SetDictionary(dictionary)
+		return remap, nil
+	}
+	m.Dictionary = dictionary
+	return remap, nil
+}
+
+// minimum of the block in the zone map of the column as an int for dictionary codes and a float64 otherwise, the values
+// of delta and frame of reference encoded blocks are stored relative to it, 0 if the block only holds NULLs
+func (m *Metadata) BlockMin(blockIdx int) any {
+	switch m.Type.(type) {
+	case int8:
+		return blockMin(m.ZoneMapIndexInt8[blockIdx], 0)
+	case int16:
+		return blockMin(m.ZoneMapIndexInt16[blockIdx], 0)
+	case int32:
+		return blockMin(m.ZoneMapIndexInt32[blockIdx], 0)
+	}
+	return blockMin(m.ZoneMapIndexFloat64[blockIdx], 0.0)
+}
+
+func blockMin[T ZoneMapValue, R int | float64](zm ZoneMap[T], null R) R {
+	if zm.Min > zm.Max {
+		return null
+	}
+	return R(zm.Min)
+}
+
+// convert a dictionary code to the code width of the column
+func (m *Metadata) Code(code int) any {
+	switch m.Type.(type) {
+	case int16:
+		return int16(code)
+	case int32:
+		return int32(code)
+	}
+	return int8(code)
+}
+
+// create indexes for new data block starting at byteOffset of the column file
+func (m *Metadata) InitBlockIndexes(byteOffset int64) {
+	m.NumBlocks += 1
 	if m.ZoneMapIndexInt8 != nil {
 		m.ZoneMapIndexInt8 = append(m.ZoneMapIndexInt8, ZoneMap[int8]{Min: math.MaxInt8})
 	}
+	if m.ZoneMapIndexInt16 != nil {
+		m.ZoneMapIndexInt16 = append(m.ZoneMapIndexInt16, ZoneMap[int16]{Min: math.MaxInt16})
+	}
+	if m.ZoneMapIndexInt32 != nil {
+		m.ZoneMapIndexInt32 = append(m.ZoneMapIndexInt32, ZoneMap[int32]{Min: math.MaxInt32})
+	}
 	if m.ZoneMapIndexFloat64 != nil {
 		m.ZoneMapIndexFloat64 = append(m.ZoneMapIndexFloat64, ZoneMap[float64]{Min: math.MaxFloat64})
 	}
 	if m.BitMapIndex != nil {
-		bitMap := make([]bool, len(TownToInt)) // only town column will compute bit map
+		bitMap := make([]bool, len(m.Dictionary)) // one bit per dictionary code
 		m.BitMapIndex = append(m.BitMapIndex, bitMap)
 	}
 	if m.OffsetMapIndex != nil {
-		m.OffsetMapIndex = append(m.OffsetMapIndex, blockSize*m.DataSizeByte)
+		m.OffsetMapIndex = append(m.OffsetMapIndex, byteOffset)
+	}
+	m.BlockEncodings = append(m.BlockEncodings, BlockPlain) // set when the block is written
+	if m.ValidityIndex != nil {
+		m.ValidityIndex = append(m.ValidityIndex, nil)
+	}
+}
+
+// drop the blocks of the column and their indexes, e.g. before the blocks are written again, the indexes computed for the
+// column stay the same
+func (m *Metadata) ResetBlockIndexes() {
+	m.NumBlocks, m.Segments, m.BlockEncodings, m.CompressedBlocks = 0, nil, nil, 0
+	if m.ZoneMapIndexInt8 != nil {
+		m.ZoneMapIndexInt8 = []ZoneMap[int8]{}
+	}
+	if m.ZoneMapIndexInt16 != nil {
+		m.ZoneMapIndexInt16 = []ZoneMap[int16]{}
+	}
+	if m.ZoneMapIndexInt32 != nil {
+		m.ZoneMapIndexInt32 = []ZoneMap[int32]{}
+	}
+	if m.ZoneMapIndexFloat64 != nil {
+		m.ZoneMapIndexFloat64 = []ZoneMap[float64]{}
+	}
+	if m.BitMapIndex != nil {
+		m.BitMapIndex = []Bitmap{}
+	}
+	if m.OffsetMapIndex != nil {
+		m.OffsetMapIndex = []int64{}
+	}
+	if m.ValidityIndex != nil {
+		m.ValidityIndex = []Validity{}
 	}
 }
 
-// update latest block indexes
-func (m *Metadata) UpdateBlockIndexes(val any) {
+// update latest block indexes with the value of a row of the block, NULL values are only recorded in the validity bit map
+func (m *Metadata) UpdateBlockIndexes(row int, val any) {
+	if m.ValidityIndex != nil {
+		currentValidity := &m.ValidityIndex[len(m.ValidityIndex)-1]
+		*currentValidity = currentValidity.set(row, val != Null)
+	}
+	if val == Null {
+		return
+	}
 	if m.ZoneMapIndexInt8 != nil {
 		v := val.(int8)
 		currentZoneMap := &m.ZoneMapIndexInt8[len(m.ZoneMapIndexInt8)-1]
 		currentZoneMap.Max = max(currentZoneMap.Max, v)
 		currentZoneMap.Min = min(currentZoneMap.Min, v)
 	}
+	if m.ZoneMapIndexInt16 != nil {
+		v := val.(int16)
+		currentZoneMap := &m.ZoneMapIndexInt16[len(m.ZoneMapIndexInt16)-1]
+		currentZoneMap.Max = max(currentZoneMap.Max, v)
+		currentZoneMap.Min = min(currentZoneMap.Min, v)
+	}
+	if m.ZoneMapIndexInt32 != nil {
+		v := val.(int32)
+		currentZoneMap := &m.ZoneMapIndexInt32[len(m.ZoneMapIndexInt32)-1]
+		currentZoneMap.Max = max(currentZoneMap.Max, v)
+		currentZoneMap.Min = min(currentZoneMap.Min, v)
+	}
 	if m.ZoneMapIndexFloat64 != nil {
 		v := val.(float64)
 		currentZoneMap := &m.ZoneMapIndexFloat64[len(m.ZoneMapIndexFloat64)-1]
@@ -121,8 +282,69 @@ func (m *Metadata) UpdateBlockIndexes(val any) {
 		currentZoneMap.Min = min(currentZoneMap.Min, v)
 	}
 	if m.BitMapIndex != nil {
-		m.BitMapIndex[len(m.BitMapIndex)-1][val.(int8)] = true
+		m.BitMapIndex[len(m.BitMapIndex)-1][CodeIdx(val)] = true
+	}
+}
+
+// name of the sidecar file the dictionary of the column is stored in, next to the catalog
+func (m *Metadata) DictionaryFile() string {
+	return "dict_" + m.Name
+}
+
+// name of the column file the column store is initialized with
+func (m *Metadata) MainFile() string {
+	return "rle_" + m.Name
+}
+
+// name of the column file of a new delta segment
+func (m *Metadata) NextDeltaFile() string {
+	return fmt.Sprintf("delta%d_%s", len(m.SegmentFiles()), m.Name)
+}
+
+// start a segment of the next data blocks in file, the last segment is continued if it is in the same file
+func (m *Metadata) StartSegment(file string) {
+	if len(m.Segments) > 0 && m.Segments[len(m.Segments)-1].File == file {
+		return
+	}
+	m.Segments = append(m.Segments, Segment{Block: m.NumBlocks, File: file})
+}
+
+// names of the column files of the column, without segments the column is only in its main file
+func (m *Metadata) SegmentFiles() []string {
+	files := []string{}
+	for _, segment := range m.Segments {
+		if !slices.Contains(files, segment.File) {
+			files = append(files, segment.File)
+		}
+	}
+	if len(files) == 0 {
+		files = append(files, m.MainFile())
+	}
+	return files
+}
+
+// column file and byte range of a data block, the block ends where the next block in the same file starts
+func (m *Metadata) BlockLocation(blockIdx int) (file string, offset int64, limit int64) {
+	file, offset, limit = m.BlockFile(blockIdx), m.OffsetMapIndex[blockIdx], -1
+	for next := blockIdx + 1; next < len(m.OffsetMapIndex); next++ {
+		if m.BlockFile(next) == file {
+			limit = m.OffsetMapIndex[next]
+			break
+		}
 	}
+	return file, offset, limit
+}
+
+// column file of a data block
+func (m *Metadata) BlockFile(blockIdx int) string {
+	file := m.MainFile()
+	for _, segment := range m.Segments {
+		if segment.Block > blockIdx {
+			break
+		}
+		file = segment.File
+	}
+	return file
 }
 
 // get metadata based on column name
@@ -134,3 +356,84 @@ func (ms Metadatas) GetColMetadata(name string) *Metadata {
 	}
 	return nil
 }
+
+// encode a raw value to the type stored in the column, used to compare query values against stored data
+func (m *Metadata) Encode(value string) (any, error) {
+	switch m.Type.(type) {
+	case int8, int16, int32:
+		code, ok := m.Dictionary.Code(value)
+		if !ok {
+			return nil, fmt.Errorf("value %q not in dictionary of column %s", value, m.Name)
+		}
+		return m.Code(code), nil
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for column %s", value, m.Name)
+		}
+		return f, nil
+	}
+	return value, nil
+}
+
+// whether row of the block is NULL
+func (m *Metadata) IsNull(blockIdx, row int) bool {
+	return m.ValidityIndex != nil && !m.ValidityIndex[blockIdx].IsValid(row)
+}
+
+// whether the block has NULL values
+func (m *Metadata) HasNulls(blockIdx int) bool {
+	return m.ValidityIndex != nil && m.ValidityIndex[blockIdx] != nil
+}
+
+// value written to the raw column files in place of NULLs, NaN for float64 columns as parsed values are never NaN and
+// the empty string otherwise as strings of nullable columns are only empty when NULL
+func (m *Metadata) RawNull() any {
+	if m.Type == float64(0) {
+		return math.NaN()
+	}
+	return ""
+}
+
+// whether a value read from a raw column file is NULL
+func (m *Metadata) IsRawNull(val any) bool {
+	if !m.Nullable {
+		return false
+	}
+	switch v := val.(type) {
+	case float64:
+		return math.IsNaN(v)
+	case string:
+		return v == ""
+	}
+	return false
+}
+
+// get zone map index of the column for values of type T, nil if the column has no such zone map
+func ZoneMapIndex[T ZoneMapValue](m *Metadata) []ZoneMap[T] {
+	var index any
+	switch any(*new(T)).(type) {
+	case int8:
+		index = m.ZoneMapIndexInt8
+	case int16:
+		index = m.ZoneMapIndexInt16
+	case int32:
+		index = m.ZoneMapIndexInt32
+	case float64:
+		index = m.ZoneMapIndexFloat64
+	}
+	return index.([]ZoneMap[T])
+}
+
+// convert a dictionary code of any width to an index into the dictionary
+func CodeIdx(code any) int {
+	switch c := code.(type) {
+	case int8:
+		return int(c)
+	case int16:
+		return int(c)
+	case int32:
+		return int(c)
+	}
+	return -1
+}
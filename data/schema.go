@@ -0,0 +1,293 @@
+package data
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// logical column types
+const (
+	TypeString  = "string"
+	TypeFloat64 = "float64"
+)
+
+// column encodings
+const (
+	EncodingDictionary       = "dictionary"
+	EncodingRunLength        = "run_length"
+	EncodingBitPacked        = "bit_packed"
+	EncodingDelta            = "delta"
+	EncodingFrameOfReference = "frame_of_reference"
+	EncodingBlockDictionary  = "block_dictionary"
+)
+
+// general purpose compressions of the encoded blocks of a column
+const (
+	CompressionFlate = "flate"
+	CompressionLZW   = "lzw"
+)
+
+// column indexes
+const (
+	IndexZoneMap   = "zone_map"
+	IndexBitMap    = "bit_map"
+	IndexOffsetMap = "offset_map"
+)
+
+// schema of the raw csv, declares how each column is stored and indexed in the column store
+type Schema struct {
+	Columns      []ColumnSchema `json:"columns"`                  // columns in the order they appear in the csv
+	SortKey      SortKey        `json:"sort_key"`                 // columns the column store is sorted on
+	ZOrder       []string       `json:"z_order,omitempty"`        // columns the rows are clustered on along a z-order curve instead of sorted
+	ZOrderBounds [][]uint64     `json:"z_order_bounds,omitempty"` // bounds of the ranges of every z-order column, found during initialization
+	Dialect      Dialect        `json:"dialect"`                  // dialect of the raw csv
+}
+
+// columns the column store is sorted on, rows are ordered on the first column, rows with equal values in it on the
+// second column, and so on, in schema files it is either a single column name or a list of column names
+type SortKey []string
+
+// declaration of a single column
+type ColumnSchema struct {
+	Name        string   `json:"name"`                  // name of column
+	Type        string   `json:"type"`                  // logical type of the raw values
+	Encodings   []string `json:"encodings"`             // encodings applied when stored
+	Compression string   `json:"compression,omitempty"` // compression of the encoded blocks, none if empty
+	Indexes     []string `json:"indexes"`               // indexes computed per block
+	Nullable    bool     `json:"nullable"`              // whether empty fields are kept as NULL instead of dropping the row
+}
+
+// schema of ResalePricesSingapore.csv, used when no schema file is given
+//
+//go:embed resale_prices_schema.json
+var defaultSchemaJSON []byte
+
+// parse the built-in schema of ResalePricesSingapore.csv
+func DefaultSchema() *Schema {
+	schema, err := parseSchema(defaultSchemaJSON)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in schema: %s", err))
+	}
+	return schema
+}
+
+// load and validate a schema from a json file
+func LoadSchema(path string) (*Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema %s: %w", path, err)
+	}
+	schema, err := parseSchema(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+func parseSchema(b []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.validate(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// check that the schema only declares supported types, encodings and indexes
+func (s *Schema) validate() error {
+	if len(s.Columns) == 0 {
+		return fmt.Errorf("schema has no columns")
+	}
+	names := map[string]bool{}
+	for _, col := range s.Columns {
+		if col.Name == "" {
+			return fmt.Errorf("column without a name")
+		}
+		if names[col.Name] {
+			return fmt.Errorf("duplicate column %s", col.Name)
+		}
+		names[col.Name] = true
+
+		if col.Type != TypeString && col.Type != TypeFloat64 {
+			return fmt.Errorf("column %s has unsupported type %q", col.Name, col.Type)
+		}
+		for _, encoding := range col.Encodings {
+			switch encoding {
+			case EncodingDictionary:
+				if col.Type != TypeString {
+					return fmt.Errorf("column %s: only string columns can be dictionary encoded", col.Name)
+				}
+			case EncodingRunLength:
+			case EncodingBitPacked:
+				if !col.HasEncoding(EncodingDictionary) {
+					return fmt.Errorf("column %s: only dictionary encoded columns can be bit packed", col.Name)
+				}
+			case EncodingDelta:
+				if !col.HasEncoding(EncodingDictionary) || !col.HasIndex(IndexZoneMap) {
+					return fmt.Errorf("column %s: only dictionary encoded columns with a zone map can be delta encoded", col.Name)
+				}
+			case EncodingFrameOfReference:
+				if col.Type != TypeFloat64 || !col.HasIndex(IndexZoneMap) {
+					return fmt.Errorf("column %s: only float64 columns with a zone map can be frame of reference encoded", col.Name)
+				}
+			case EncodingBlockDictionary:
+				if col.Type != TypeString || col.HasEncoding(EncodingDictionary) {
+					return fmt.Errorf("column %s: only string columns without a dictionary can have block dictionaries", col.Name)
+				}
+			default:
+				return fmt.Errorf("column %s has unsupported encoding %q", col.Name, encoding)
+			}
+		}
+		if col.Compression != "" && col.Compression != CompressionFlate && col.Compression != CompressionLZW {
+			return fmt.Errorf("column %s has unsupported compression %q", col.Name, col.Compression)
+		}
+		for _, index := range col.Indexes {
+			switch index {
+			case IndexZoneMap:
+				if col.Type == TypeString && !col.HasEncoding(EncodingDictionary) {
+					return fmt.Errorf("column %s: zone maps need a float64 or dictionary encoded column", col.Name)
+				}
+			case IndexBitMap:
+				if !col.HasEncoding(EncodingDictionary) {
+					return fmt.Errorf("column %s: bit maps need a dictionary encoded column", col.Name)
+				}
+			case IndexOffsetMap:
+				if col.Type == TypeString && !col.HasEncoding(EncodingDictionary) {
+					return fmt.Errorf("column %s: offset maps need a fixed width column", col.Name)
+				}
+			default:
+				return fmt.Errorf("column %s has unsupported index %q", col.Name, index)
+			}
+		}
+	}
+	for i, name := range s.SortKey {
+		if !names[name] {
+			return fmt.Errorf("sort key %s is not a column", name)
+		}
+		if slices.Contains(s.SortKey[:i], name) {
+			return fmt.Errorf("sort key %s is repeated", name)
+		}
+	}
+	if len(s.ZOrder) > 0 && len(s.SortKey) > 0 {
+		return fmt.Errorf("rows are either sorted on a sort key or clustered on z-order columns, not both")
+	}
+	if len(s.ZOrder) == 1 {
+		return fmt.Errorf("z-order needs at least 2 columns, use a sort key for 1 column")
+	}
+	for i, name := range s.ZOrder {
+		if !names[name] {
+			return fmt.Errorf("z-order column %s is not a column", name)
+		}
+		if slices.Contains(s.ZOrder[:i], name) {
+			return fmt.Errorf("z-order column %s is repeated", name)
+		}
+	}
+	if s.ZOrderBounds != nil && len(s.ZOrderBounds) != len(s.ZOrder) {
+		return fmt.Errorf("z-order has bounds for %d columns, expected %d", len(s.ZOrderBounds), len(s.ZOrder))
+	}
+	return s.Dialect.validate()
+}
+
+// replace the dialect of the schema, e.g. with a dialect given when initializing or appending to the column store
+func (s *Schema) SetDialect(dialect Dialect) error {
+	if err := dialect.validate(); err != nil {
+		return err
+	}
+	s.Dialect = dialect
+	return nil
+}
+
+// replace the sort key of the schema, e.g. with a sort key given when initializing the column store
+func (s *Schema) SetSortKey(names []string) error {
+	schema := *s
+	schema.SortKey = names
+	if err := schema.validate(); err != nil {
+		return err
+	}
+	s.SortKey = names
+	return nil
+}
+
+// cluster the rows on the z-order columns instead of sorting them on the sort key, the bounds of the ranges of the
+// columns are found when initializing the column store
+func (s *Schema) SetZOrder(names []string) error {
+	schema := *s
+	schema.SortKey, schema.ZOrder, schema.ZOrderBounds = nil, names, nil
+	if err := schema.validate(); err != nil {
+		return err
+	}
+	*s = schema
+	return nil
+}
+
+// a sort key is either a single column name or a list of column names
+func (k *SortKey) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*k = SortKey{}
+		if name != "" {
+			*k = SortKey{name}
+		}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("sort key must be a column name or a list of column names")
+	}
+	*k = names
+	return nil
+}
+
+// whether the column is stored with the encoding
+func (c ColumnSchema) HasEncoding(encoding string) bool {
+	return slices.Contains(c.Encodings, encoding)
+}
+
+// whether the column has the index
+func (c ColumnSchema) HasIndex(index string) bool {
+	return slices.Contains(c.Indexes, index)
+}
+
+// compare 2 rows on the z-order curve if the rows are clustered on z-order columns, otherwise on the sort key, column by
+// column, NULLs sort first, all rows compare equal if there is no sort key
+func (s *Schema) Less(a, b CsvData) bool {
+	if len(s.ZOrder) > 0 {
+		return s.zOrderLess(a, b)
+	}
+	return s.lessOn(s.SortKey, a, b)
+}
+
+// compare 2 rows on the columns, column by column, NULLs sort first
+func (s *Schema) lessOn(names []string, a, b CsvData) bool {
+	for _, name := range names {
+		idx := s.colIdx(name)
+		if a[idx] == b[idx] {
+			continue
+		}
+		if a[idx] == Null || b[idx] == Null {
+			return a[idx] == Null
+		}
+		switch av := a[idx].(type) {
+		case string:
+			return av < b[idx].(string)
+		case float64:
+			return av < b[idx].(float64)
+		}
+	}
+	return false
+}
+
+// index of a column in the csv, -1 if it doesn't exist
+func (s *Schema) colIdx(name string) int {
+	for i, col := range s.Columns {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
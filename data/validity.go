@@ -0,0 +1,39 @@
+package data
+
+// NULL value of nullable columns, empty fields of nullable columns are parsed to it
+var Null any = null{}
+
+type null struct{}
+
+// validity bit map of a block, bit i is set when row i of the block is not NULL, blocks without NULLs have a nil
+// validity bit map so columns that are never NULL don't pay for it
+type Validity []byte
+
+// whether row of the block is not NULL
+func (v Validity) IsValid(row int) bool {
+	if v == nil {
+		return true
+	}
+	return row/8 < len(v) && v[row/8]&(1<<(row%8)) != 0
+}
+
+// record whether row of the block is valid, rows must be set in order, the bit map is only allocated at the first NULL
+func (v Validity) set(row int, valid bool) Validity {
+	if v == nil {
+		if valid {
+			return nil
+		}
+		// every previous row of the block is valid
+		v = make(Validity, row/8+1)
+		for i := range row {
+			v[i/8] |= 1 << (i % 8)
+		}
+	}
+	for len(v) <= row/8 {
+		v = append(v, 0)
+	}
+	if valid {
+		v[row/8] |= 1 << (row % 8)
+	}
+	return v
+}
@@ -1,13 +1,21 @@
 package data
 
-type ZoneMap[T int8 | float64] struct {
+// types of column values zone maps can be computed on
+type ZoneMapValue interface {
+	int8 | int16 | int32 | float64
+}
+
+type ZoneMap[T ZoneMapValue] struct {
 	Min T
 	Max T
 }
 
 // check if a block can be skipped (not loaded to memory) and if the block or part of it qualifies for further filtering
 func (zm ZoneMap[T]) Check(queryInclusiveMin, queryInclusiveMax T) (skippable bool, qualified bool) {
-	if queryInclusiveMax < zm.Min || queryInclusiveMin > zm.Max { 
+	if zm.Min > zm.Max {
+		// block only has NULL values, skippable and block doesnt qualify
+		return true, false
+	} else if queryInclusiveMax < zm.Min || queryInclusiveMin > zm.Max { 
 		// zone map out of filter range, skippable and block doesnt qualify
 		return true, false
 	} else if queryInclusiveMin <= zm.Min && queryInclusiveMax >= zm.Max { 
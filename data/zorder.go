@@ -0,0 +1,94 @@
+package data
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+// most ranges a z-order column is cut into, more ranges don't cluster rows noticeably better but grow the catalog
+const maxZOrderRanges = 1 << 16
+
+// order preserving key of a raw value used to cut z-order columns into ranges, strings are keyed on their first 8
+// bytes, so strings sharing them fall into the same range, floats on their bits with the order of negative floats
+// flipped, NULLs key lowest
+func ZOrderKey(val any) uint64 {
+	switch v := val.(type) {
+	case string:
+		var prefix [8]byte
+		copy(prefix[:], v)
+		return binary.BigEndian.Uint64(prefix[:])
+	case float64:
+		b := math.Float64bits(v)
+		if b>>63 == 1 {
+			return ^b
+		}
+		return b | 1<<63
+	}
+	return 0
+}
+
+// bounds of the ranges a z-order column is cut into given the number of rows of every key of the column, ranges hold
+// about the same number of rows, a bound is the lowest key of its range and the first range has no bound
+func NewZOrderBounds(keyCounts map[uint64]int, columns int) []uint64 {
+	keys := make([]uint64, 0, len(keyCounts))
+	rows := 0
+	for key, cnt := range keyCounts {
+		keys = append(keys, key)
+		rows += cnt
+	}
+	slices.Sort(keys)
+
+	ranges := min(1<<zOrderBits(columns), maxZOrderRanges)
+	bounds := []uint64{}
+	seen := 0
+	for i, key := range keys {
+		// start a new range once the rows before the key fill the ranges so far
+		if i > 0 && seen*ranges >= (len(bounds)+1)*rows {
+			bounds = append(bounds, key)
+		}
+		seen += keyCounts[key]
+	}
+	return bounds
+}
+
+// number of bits of the curve each z-order column gets
+func zOrderBits(columns int) int {
+	return min(64/columns, 32)
+}
+
+// compare 2 rows on the z-order curve, the value of each column is replaced by the number of its range scaled to
+// the bits of the column, rows in the same ranges are compared on the raw values column by column
+func (s *Schema) zOrderLess(a, b CsvData) bool {
+	// the column with the highest differing bit decides, on the same bit earlier columns go first
+	dim, msd := -1, uint64(0)
+	var rankA, rankB uint64
+	for i, name := range s.ZOrder {
+		idx := s.colIdx(name)
+		ra, rb := s.zOrderRank(i, a[idx]), s.zOrderRank(i, b[idx])
+		if diff := ra ^ rb; msd < diff && msd < msd^diff {
+			dim, msd = i, diff
+			rankA, rankB = ra, rb
+		}
+	}
+	if dim != -1 {
+		return rankA < rankB
+	}
+	return s.lessOn(s.ZOrder, a, b)
+}
+
+// number of the range of a value of the i-th z-order column scaled to the bits of the column
+func (s *Schema) zOrderRank(i int, val any) uint64 {
+	// without bounds, before they are found, rows are compared on the raw values
+	if i >= len(s.ZOrderBounds) || val == Null {
+		return 0
+	}
+	bounds := s.ZOrderBounds[i]
+	key := ZOrderKey(val)
+	rank := uint64(sort.Search(len(bounds), func(j int) bool { return bounds[j] > key }))
+	hi, lo := bits.Mul64(rank, 1<<zOrderBits(len(s.ZOrder)))
+	scaled, _ := bits.Div64(hi, lo, uint64(len(bounds)+1))
+	return scaled
+}
@@ -1,48 +1,256 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sc4023/custom"
 	"sc4023/data"
 	"sc4023/query"
 	"sc4023/store"
 	"sc4023/utils"
+	"strings"
 	"time"
 )
 
+const usage = `Usage: sc4023 <command> [flags]
+
+Commands:
+  init     build the column store from a raw csv
+  append   append the rows of a csv to an initialized column store
+  query    run the query against an initialized column store
+  inspect  print the layout of an initialized column store
+
+Run 'sc4023 <command> -h' for the flags of a command.
+`
+
 func main() {
-	utils.CleanDir("./column_store")
-	month, town, area, dataPath, matric := utils.ParseFlags()
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(utils.ExitUsage)
+	}
+
+	switch os.Args[1] {
+	case "init":
+		os.Exit(runInit(os.Args[2:]))
+	case "append":
+		os.Exit(runAppend(os.Args[2:]))
+	case "query":
+		os.Exit(runQuery(os.Args[2:]))
+	case "inspect":
+		os.Exit(runInspect(os.Args[2:]))
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		os.Exit(utils.ExitOk)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(utils.ExitUsage)
+	}
+}
+
+// build the column store from the raw csv, any existing column store in the directory is replaced
+func runInit(args []string) int {
+	flags, err := utils.ParseInitFlags(args)
+	if err != nil {
+		return flagErrorCode(err)
+	}
+
+	// build into a staging directory which replaces the column store only once it is complete
+	stagingDir, err := store.PrepareStagingDir(flags.StoreDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare staging directory: %s\n", err)
+		return utils.ExitFailure
+	}
 
 	// Simulate big data environment by only allowing loading of 2000 data points at any time
 	limitedSlice := custom.InitLimitedSlice(2000)
 
-	// initialize column store
-	sortedChunkDataPath := "./column_store/sorted_chunk.csv"
-	sortedDataPath := "./column_store/sorted.csv"
-	columnStoreMetadata := data.InitColumnStoreMetadata()
-	store := store.Store{
+	start := time.Now()
+	columnStore := store.Store{
 		LimitedSlice:        limitedSlice,
-		DataPath:            dataPath,
-		SortedChunkDataPath: sortedChunkDataPath,
-		SortedDataPath:      sortedDataPath,
-		ColumnStoreMetadata: columnStoreMetadata,
+		ColumnStoreDir:      stagingDir,
+		DataPaths:           flags.DataPaths,
+		SortedChunkDataPath: filepath.Join(stagingDir, "sorted_chunk.rows"),
+		SortedDataPath:      filepath.Join(stagingDir, "sorted.rows"),
+		CatalogPath:         filepath.Join(stagingDir, "catalog.json"),
+		Schema:              flags.Schema,
+		ColumnStoreMetadata: data.InitColumnStoreMetadata(flags.Schema),
+		MergeFanIn:          flags.MergeFanIn,
+		SortWorkers:         flags.SortWorkers,
+		RejectsPath:         filepath.Join(stagingDir, "rejects.csv"),
+		MaxRejectRatio:      flags.MaxRejectRatio,
+	}
+	if err := columnStore.InitColumnStore(); err != nil {
+		fmt.Fprintf(os.Stderr, "column store initialization failed: %s\n", err)
+		return utils.ExitFailure
+	}
+	if err := store.Publish(stagingDir, flags.StoreDir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to publish column store: %s\n", err)
+		return utils.ExitFailure
+	}
+	fmt.Printf("Column store initialized in %s (%s)\n", flags.StoreDir, time.Since(start))
+	return utils.ExitOk
+}
+
+// append the rows of a csv to an existing column store, the column store is replaced by an appended copy
+func runAppend(args []string) int {
+	flags, err := utils.ParseAppendFlags(args)
+	if err != nil {
+		return flagErrorCode(err)
+	}
+
+	storeDir, release, err := store.CheckComplete(flags.StoreDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no usable column store in %s, run init first: %s\n", flags.StoreDir, err)
+		return utils.ExitNoStore
+	}
+	defer release()
+	catalog, err := data.ReadCatalog(filepath.Join(storeDir, "catalog.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no usable column store in %s, run init first: %s\n", flags.StoreDir, err)
+		return utils.ExitNoStore
+	}
+	if err := flags.Dialect.Apply(catalog.Schema); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return utils.ExitUsage
 	}
-	store.InitColumnStore()
 
-	// run query based on the matric number
+	// append to a copy in the staging directory, so queries keep seeing the column store without the new rows until
+	// the append is complete
+	stagingDir, err := store.PrepareAppendDir(flags.StoreDir, storeDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare staging directory: %s\n", err)
+		return utils.ExitFailure
+	}
+
+	// Simulate big data environment by only allowing loading of 2000 data points at any time
+	limitedSlice := custom.InitLimitedSlice(2000)
+
 	start := time.Now()
-	runner := query.QueryRunner{
+	columnStore := store.Store{
 		LimitedSlice:        limitedSlice,
-		ColumnStoreMetadata: columnStoreMetadata,
-		TaskQueue:           make(chan int),
+		ColumnStoreDir:      stagingDir,
+		DataPaths:           flags.DataPaths,
+		SortedChunkDataPath: filepath.Join(stagingDir, "append_sorted_chunk.rows"),
+		SortedDataPath:      filepath.Join(stagingDir, "append_sorted.rows"),
+		CatalogPath:         filepath.Join(stagingDir, "catalog.json"),
+		Schema:              catalog.Schema,
+		ColumnStoreMetadata: catalog.Columns,
+		MergeFanIn:          flags.MergeFanIn,
+		SortWorkers:         flags.SortWorkers,
+		RejectsPath:         filepath.Join(stagingDir, "rejects.csv"),
+	}
+	if err := columnStore.AppendColumnStore(); err != nil {
+		fmt.Fprintf(os.Stderr, "append failed: %s\n", err)
+		return utils.ExitFailure
+	}
+	if err := store.Publish(stagingDir, flags.StoreDir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to publish column store: %s\n", err)
+		return utils.ExitFailure
+	}
+	fmt.Printf("Appended %s to column store in %s (%s)\n", strings.Join(flags.DataPaths, ", "), flags.StoreDir, time.Since(start))
+	return utils.ExitOk
+}
+
+// run the query against an existing column store and save the results
+func runQuery(args []string) int {
+	flags, err := utils.ParseQueryFlags(args)
+	if err != nil {
+		return flagErrorCode(err)
+	}
+
+	// the query reads the version of the column store that is current now, even if another one is published meanwhile,
+	// the version is leased so it is kept until the query is done
+	storeDir, release, err := store.CheckComplete(flags.StoreDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no usable column store in %s, run init first: %s\n", flags.StoreDir, err)
+		return utils.ExitNoStore
+	}
+	defer release()
+
+	// Simulate big data environment by only allowing loading of 2000 data points at any time
+	limitedSlice := custom.InitLimitedSlice(2000)
+
+	runner := query.QueryRunner{
+		LimitedSlice:   limitedSlice,
+		ColumnStoreDir: storeDir,
+		TaskQueue:      make(chan int),
+	}
+	if err := runner.LoadCatalog(filepath.Join(storeDir, "catalog.json")); err != nil {
+		fmt.Fprintf(os.Stderr, "no usable column store in %s, run init first: %s\n", flags.StoreDir, err)
+		return utils.ExitNoStore
+	}
+
+	// resolve filters against the column store, then plan and run the query
+	start := time.Now()
+	filters := []query.Filter{}
+	for _, f := range flags.Filters {
+		var filter query.Filter
+		if f.Exact {
+			filter, err = runner.NewExactFilter(f.Column, f.Min)
+		} else {
+			filter, err = runner.NewRangeFilter(f.Column, f.Min, f.Max)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid filter: %s\n", err)
+			return utils.ExitUsage
+		}
+		filters = append(filters, filter)
+	}
+	if err := runner.InitQueryPlan(filters, flags.AggCol, flags.PerCol); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid query: %s\n", err)
+		return utils.ExitUsage
+	}
+	results, err := runner.RunQuery()
+	var corruption *custom.CorruptionError
+	if errors.As(err, &corruption) {
+		fmt.Fprintf(os.Stderr, "column store is corrupted, initialize it again: %s\n", err)
+		return utils.ExitNoStore
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "query failed: %s\n", err)
+		return utils.ExitFailure
 	}
-	runner.InitQueryPlan(month, town, area)
-	results := runner.RunQuery()
 
 	elapsed := time.Since(start)
 	fmt.Printf("Query execution time (excluding column store init): %s\n", elapsed)
 
 	// save results to file
-	utils.SaveResults(matric, month, town, area, results)
+	if err := utils.SaveResults(flags.ResultPath, flags.ResultHeader, flags.ResultPrefix, flags.Categories[:len(results)], results); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save results: %s\n", err)
+		return utils.ExitFailure
+	}
+	return utils.ExitOk
+}
+
+// print the layout of an existing column store
+func runInspect(args []string) int {
+	flags, err := utils.ParseInspectFlags(args)
+	if err != nil {
+		return flagErrorCode(err)
+	}
+
+	storeDir, release, err := store.CheckComplete(flags.StoreDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no usable column store in %s, run init first: %s\n", flags.StoreDir, err)
+		return utils.ExitNoStore
+	}
+	defer release()
+	metadatas, err := data.LoadCatalog(filepath.Join(storeDir, "catalog.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no usable column store in %s, run init first: %s\n", flags.StoreDir, err)
+		return utils.ExitNoStore
+	}
+	utils.PrintStoreLayout(os.Stdout, storeDir, metadatas)
+	return utils.ExitOk
+}
+
+// map flag parsing errors to exit codes, -h is not an error
+func flagErrorCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return utils.ExitOk
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return utils.ExitUsage
 }
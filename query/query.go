@@ -8,20 +8,23 @@ import (
 
 // check whih blocks qualify in a range
 type Filter interface {
+	GetQualifiedBlocksRange() (int, int)
 	GetQualifiedBlocksWithinRange(start, end int) []int
+	filterColumn() *data.Metadata
+	checkIndexes(i int) (bool, bool)
 }
 
 // filters rows between InclusiveMin and InclusiveMax
-type RangeFilterQuery[T int8 | float64] struct {
+type RangeFilterQuery[T data.ZoneMapValue] struct {
 	Column       *data.Metadata
 	InclusiveMin T
 	InclusiveMax T
 }
 
-// filters rows based on exact match
+// filters rows based on exact match, Match is a dictionary code of the column's code width
 type ExactFilterQuery struct {
 	Column *data.Metadata
-	Match  int8
+	Match  any
 }
 
 // calculate minimum of a column
@@ -69,86 +72,131 @@ type Operation struct {
 // indicates aggregates to be run together
 type SharedScan []any
 
-// used by sorted month column to get range of blocks that qualify
+// used by sorted columns to get range of blocks that qualify, unsorted columns return all blocks
 func (rfq *RangeFilterQuery[T]) GetQualifiedBlocksRange() (int, int) {
-	if !rfq.Column.Sorted {
-		return -1, -1
+	return qualifiedBlocksRange(rfq.Column, rfq.checkBlock)
+}
+
+// get qualified blocks for range query
+func (rfq *RangeFilterQuery[T]) GetQualifiedBlocksWithinRange(start, end int) []int {
+	return qualifiedBlocksWithinRange(start, end, rfq.checkBlock)
+}
+
+// check if block qualifies using the zone map, blocks of columns without zone map always qualify
+func (rfq *RangeFilterQuery[T]) checkBlock(i int) bool {
+	_, qualified := rfq.checkIndexes(i)
+	return qualified
+}
+
+// check the zone map of a block, returns whether the block can be skipped or taken whole without reading it
+// and whether it qualifies, columns without zone map can't be skipped
+func (rfq *RangeFilterQuery[T]) checkIndexes(i int) (bool, bool) {
+	if zoneMaps := data.ZoneMapIndex[T](rfq.Column); zoneMaps != nil {
+		return zoneMaps[i].Check(rfq.InclusiveMin, rfq.InclusiveMax)
+	}
+	return false, true
+}
+
+func (rfq *RangeFilterQuery[T]) filterColumn() *data.Metadata {
+	return rfq.Column
+}
+
+// used by sorted columns to get range of blocks that qualify, unsorted columns return all blocks
+func (rfq *ExactFilterQuery) GetQualifiedBlocksRange() (int, int) {
+	return qualifiedBlocksRange(rfq.Column, rfq.checkBlock)
+}
+
+// get qualified blocks for exact query
+func (rfq *ExactFilterQuery) GetQualifiedBlocksWithinRange(start, end int) []int {
+	return qualifiedBlocksWithinRange(start, end, rfq.checkBlock)
+}
+
+// check if block qualifies using the bit map, blocks of columns without bit map always qualify
+func (rfq *ExactFilterQuery) checkBlock(i int) bool {
+	_, qualified := rfq.checkIndexes(i)
+	return qualified
+}
+
+// check the bit map of a block, returns whether the block can be skipped or taken whole without reading it
+// and whether it qualifies, columns without bit map can't be skipped
+func (rfq *ExactFilterQuery) checkIndexes(i int) (bool, bool) {
+	if rfq.Column.BitMapIndex != nil {
+		return rfq.Column.BitMapIndex[i].Check(data.CodeIdx(rfq.Match))
+	}
+	return false, true
+}
+
+func (rfq *ExactFilterQuery) filterColumn() *data.Metadata {
+	return rfq.Column
+}
+
+// find the first and last qualified block of the first sort key column, its qualified blocks are next to each other,
+// returns an empty range if no block qualifies, later sort key columns are only sorted within rows of equal values of
+// the columns before them so like unsorted columns they return all blocks
+func qualifiedBlocksRange(column *data.Metadata, checkBlock func(i int) bool) (int, int) {
+	numBlocks := len(column.OffsetMapIndex)
+	if !column.Sorted {
+		return 0, numBlocks - 1
 	}
-	// only month column is sorted, zone map is int8 there
 	start := -1
 	end := -1
-	for i := range len(rfq.Column.ZoneMapIndexInt8) {
-		queryMin := any(rfq.InclusiveMin).(int8)
-		queryMax := any(rfq.InclusiveMax).(int8)
-		if _, qualified := rfq.Column.ZoneMapIndexInt8[i].Check(queryMin, queryMax); qualified {
+	for i := range numBlocks {
+		if checkBlock(i) {
 			if start == -1 {
 				start = i
 			}
 			end = i
 		}
 	}
-	return start, end
-}
-
-// get qualified blocks for range query
-func (rfq *RangeFilterQuery[T]) GetQualifiedBlocksWithinRange(start, end int) []int {
-	qualBlocks := []int{}
-	if rfq.Column.ZoneMapIndexInt8 != nil {
-		for i := start; i <= end; i++ {
-			zm := rfq.Column.ZoneMapIndexInt8[i]
-			queryMin := any(rfq.InclusiveMin).(int8)
-			queryMax := any(rfq.InclusiveMax).(int8)
-			if _, qualified := zm.Check(queryMin, queryMax); qualified {
-				qualBlocks = append(qualBlocks, i)
-			}
-		}
-	}
-	if rfq.Column.ZoneMapIndexFloat64 != nil {
-		for i := start; i <= end; i++ {
-			zm := rfq.Column.ZoneMapIndexFloat64[i]
-			queryMin := any(rfq.InclusiveMin).(float64)
-			queryMax := any(rfq.InclusiveMax).(float64)
-			if _, qualified := zm.Check(queryMin, queryMax); qualified {
-				qualBlocks = append(qualBlocks, i)
-			}
-		}
+	if start == -1 {
+		return 0, -1
 	}
-	return qualBlocks
+	return start, end
 }
 
-// get qualified blocks for exact query
-func (rfq *ExactFilterQuery) GetQualifiedBlocksWithinRange(start, end int) []int {
+// check each block from start to end and return the qualified ones
+func qualifiedBlocksWithinRange(start, end int, checkBlock func(i int) bool) []int {
 	qualBlocks := []int{}
 	for i := start; i <= end; i++ {
-		if _, qualified := rfq.Column.BitMapIndex[i].Check(rfq.Match); qualified {
+		if checkBlock(i) {
 			qualBlocks = append(qualBlocks, i)
 		}
 	}
 	return qualBlocks
 }
 
-// evaluates whether the data is qualified or must be filtered out
+// evaluates whether the data is qualified or must be filtered out, like in SQL comparisons with NULL never qualify
 func evaluateFilter(query, val any) bool {
+	if val == data.Null {
+		return false
+	}
 	switch query := query.(type) {
 	case *RangeFilterQuery[int8]:
-		val := val.(int8)
-		if val <= query.InclusiveMax && val >= query.InclusiveMin {
-			return true
-		}
+		return inRange(query, val)
+	case *RangeFilterQuery[int16]:
+		return inRange(query, val)
+	case *RangeFilterQuery[int32]:
+		return inRange(query, val)
 	case *RangeFilterQuery[float64]:
-		val := val.(float64)
-		if val <= query.InclusiveMax && val >= query.InclusiveMin {
-			return true
-		}
+		return inRange(query, val)
 	case *ExactFilterQuery:
 		return val == query.Match
 	}
 	return false
 }
 
+// check if the data is between the bounds of the range filter
+func inRange[T data.ZoneMapValue](query *RangeFilterQuery[T], val any) bool {
+	v := val.(T)
+	return v <= query.InclusiveMax && v >= query.InclusiveMin
+}
+
 // updates aggregate result based on the data point, need to lock because multiple
-// Go routines might be updating the same aggregate query
+// Go routines might be updating the same aggregate query, like in SQL NULLs are ignored
 func evaluateAggregate(query, val any) {
+	if val == data.Null {
+		return
+	}
 	switch query := query.(type) {
 	case *MinQuery:
 		query.Lock.Lock()
@@ -170,6 +218,14 @@ func evaluateAggregate(query, val any) {
 	}
 }
 
+// perform operation between data points from 2 columns, the result is NULL if either is NULL
+func (op OpType) apply(x, y any) any {
+	if x == data.Null || y == data.Null {
+		return data.Null
+	}
+	return op.compute(x.(float64), y.(float64))
+}
+
 // perform operation between data points from 2 columns
 func (op OpType) compute(x, y float64) float64 {
 	switch op {
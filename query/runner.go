@@ -3,6 +3,7 @@ package query
 import (
 	"fmt"
 	"math"
+	"path/filepath"
 	"sc4023/custom"
 	"sc4023/data"
 	"sc4023/utils"
@@ -12,69 +13,178 @@ import (
 
 type QueryRunner struct {
 	LimitedSlice        custom.LimitedSlice // limited slice where queries are run
+	ColumnStoreDir      string              // directory of the column files
 	ColumnStoreMetadata data.Metadatas      // metadata of each column
 	QueryPlan           []any               // query plan to be executed by each worker
-	QualifiedBlocks     []int               // qualified blocks from initial filtering on the sorted month column
+	QualifiedBlocks     []int               // qualified blocks from initial filtering on the sorted columns
 	TaskQueue           chan int            // channel for distributing tasks between workers
 	wg                  sync.WaitGroup      // wait group to wait until all workers finish execution
+	errOnce             sync.Once           // keeps the first error of the workers
+	err                 error               // first error of the workers, e.g. a corrupted column file
 }
 
-// initialize the query plan, this will be run by each worker which processes each qualified block absed on this plan
-func (q *QueryRunner) InitQueryPlan(month int8, town int8, area float64) {
-	// intialize plan and filters
-	plan := []any{}
-	filters := []Filter{}
-	filterMonth := RangeFilterQuery[int8]{
-		Column:       q.ColumnStoreMetadata.GetColMetadata("month"),
-		InclusiveMin: month,
-		InclusiveMax: month + 1,
+// load column metadata and indexes from the catalog written during column store initialization
+func (q *QueryRunner) LoadCatalog(path string) error {
+	metadatas, err := data.LoadCatalog(path)
+	if err != nil {
+		return err
+	}
+	q.ColumnStoreMetadata = metadatas
+	return nil
+}
+
+// create a range filter on a column from raw values, an empty min or max leaves that side of the range unbounded
+func (q *QueryRunner) NewRangeFilter(colName, min, max string) (Filter, error) {
+	column, err := q.getQueryableColumn(colName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch column.Type.(type) {
+	case int8:
+		return newRangeFilter[int8](column, min, max, math.MinInt8, math.MaxInt8)
+	case int16:
+		return newRangeFilter[int16](column, min, max, math.MinInt16, math.MaxInt16)
+	case int32:
+		return newRangeFilter[int32](column, min, max, math.MinInt32, math.MaxInt32)
+	case float64:
+		return newRangeFilter(column, min, max, -math.MaxFloat64, math.MaxFloat64)
+	}
+	return nil, fmt.Errorf("range filters are not supported on %T column %s", column.Type, colName)
+}
+
+// create an exact match filter on a dictionary encoded column from a raw value
+func (q *QueryRunner) NewExactFilter(colName, match string) (Filter, error) {
+	column, err := q.getQueryableColumn(colName)
+	if err != nil {
+		return nil, err
+	}
+	if !column.DictionaryEncode {
+		return nil, fmt.Errorf("exact filters are only supported on dictionary encoded columns, %s is not", colName)
 	}
-	filterTown := ExactFilterQuery{
-		Column: q.ColumnStoreMetadata.GetColMetadata("town"),
-		Match:  town,
+
+	// values not in the dictionary match no rows, -1 is no code
+	code, ok := column.Dictionary.Code(match)
+	if !ok {
+		code = -1
+	}
+	return &ExactFilterQuery{Column: column, Match: column.Code(code)}, nil
+}
+
+// initialize the query plan, this will be run by each worker which processes each qualified block based on this plan,
+// rows have to pass all filters, then minimum, average, and standard deviation of aggCol are computed together with
+// the minimum of aggCol per perCol, perCol is optional
+func (q *QueryRunner) InitQueryPlan(filters []Filter, aggCol string, perCol string) error {
+	plan := []any{}
+	aggColumn, err := q.getQueryableColumn(aggCol)
+	if err != nil {
+		return err
 	}
-	filterArea := RangeFilterQuery[float64]{
-		Column:       q.ColumnStoreMetadata.GetColMetadata("floor_area_sqm"),
-		InclusiveMin: area,
-		InclusiveMax: math.MaxFloat64,
+	if _, ok := aggColumn.Type.(float64); !ok {
+		return fmt.Errorf("aggregates are only supported on float64 columns, %s is not", aggCol)
 	}
 
-	// get range of qualified blocks from the sorted month column, then sort filters
+	// get range of qualified blocks from sorted columns, then sort filters
 	// based on the least number of qualified blocks
-	start, end := filterMonth.GetQualifiedBlocksRange()
-	filters = append(filters, &filterMonth, &filterTown, &filterArea)
+	start, end := 0, len(aggColumn.OffsetMapIndex)-1
+	for _, filter := range filters {
+		filterStart, filterEnd := filter.GetQualifiedBlocksRange()
+		start, end = max(start, filterStart), min(end, filterEnd)
+	}
 	sort.Slice(filters, func(i, j int) bool {
 		return len(filters[i].GetQualifiedBlocksWithinRange(start, end)) <
 			len(filters[j].GetQualifiedBlocksWithinRange(start, end))
 	})
 
-	// get the initial qualifying blocks and append filters to the plan
-	q.QualifiedBlocks = filters[0].GetQualifiedBlocksWithinRange(start, end)
+	// get the initial qualifying blocks and append filters to the plan, without filters all blocks qualify
+	q.QualifiedBlocks = []int{}
+	if len(filters) > 0 {
+		q.QualifiedBlocks = filters[0].GetQualifiedBlocksWithinRange(start, end)
+	} else {
+		for i := start; i <= end; i++ {
+			q.QualifiedBlocks = append(q.QualifiedBlocks, i)
+		}
+	}
 	for _, filter := range filters {
 		plan = append(plan, filter)
 	}
 
-	// min, average, and stdev price can be queried together with shared scan
-	priceCol := q.ColumnStoreMetadata.GetColMetadata("resale_price")
+	// min, average, and stdev can be queried together with shared scan
 	plan = append(plan, SharedScan{
-		&MinQuery{Column: priceCol, Lock: &sync.Mutex{}, Result: math.MaxFloat64},
-		&AvgQuery{Column: priceCol, Lock: &sync.Mutex{}},
-		&StdevQuery{Column: priceCol, Lock: &sync.Mutex{}},
+		&MinQuery{Column: aggColumn, Lock: &sync.Mutex{}, Result: math.MaxFloat64},
+		&AvgQuery{Column: aggColumn, Lock: &sync.Mutex{}},
+		&StdevQuery{Column: aggColumn, Lock: &sync.Mutex{}},
 	})
 
-	// for min price per area, need to perform division operation first, then do a MinQuery with column nil
-	areaCol := q.ColumnStoreMetadata.GetColMetadata("floor_area_sqm")
-	plan = append(plan, &Operation{Column: areaCol, Op: Divide})
-	plan = append(plan, SharedScan{&MinQuery{Lock: &sync.Mutex{}, Result: math.MaxFloat64}}) // represent as single shared scan to reduce code
+	// for min per column, need to perform division operation first, then do a MinQuery with column nil
+	if perCol != "" {
+		perColumn, err := q.getQueryableColumn(perCol)
+		if err != nil {
+			return err
+		}
+		if _, ok := perColumn.Type.(float64); !ok {
+			return fmt.Errorf("operations are only supported on float64 columns, %s is not", perCol)
+		}
+		plan = append(plan, &Operation{Column: perColumn, Op: Divide})
+		plan = append(plan, SharedScan{&MinQuery{Lock: &sync.Mutex{}, Result: math.MaxFloat64}}) // represent as single shared scan to reduce code
+	}
 
 	q.QueryPlan = plan
+	return nil
+}
+
+// get metadata of a column that can be read block by block, which needs an offset map
+func (q *QueryRunner) getQueryableColumn(colName string) (*data.Metadata, error) {
+	column := q.ColumnStoreMetadata.GetColMetadata(colName)
+	if column == nil {
+		return nil, fmt.Errorf("unknown column %s", colName)
+	}
+	if column.OffsetMapIndex == nil {
+		return nil, fmt.Errorf("column %s has no offset map index and can't be queried", colName)
+	}
+	return column, nil
+}
+
+// create a range filter with raw bounds, empty bounds default to lowest and highest, bounds on dictionary encoded
+// columns are the codes of the first and last value in the range, which matches no rows if no value is in it
+func newRangeFilter[T data.ZoneMapValue](column *data.Metadata, min, max string, lowest, highest T) (Filter, error) {
+	filter := &RangeFilterQuery[T]{Column: column, InclusiveMin: lowest, InclusiveMax: highest}
+	if column.DictionaryEncode {
+		first, last := column.Dictionary.CodeRange(min, max)
+		if first > last {
+			filter.InclusiveMin, filter.InclusiveMax = highest, lowest
+		} else {
+			filter.InclusiveMin, filter.InclusiveMax = column.Code(first).(T), column.Code(last).(T)
+		}
+		return filter, nil
+	}
+	if err := encodeBound(column, min, &filter.InclusiveMin); err != nil {
+		return nil, err
+	}
+	if err := encodeBound(column, max, &filter.InclusiveMax); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// encode a raw range bound into dst, empty bounds are left unchanged
+func encodeBound[T data.ZoneMapValue](column *data.Metadata, value string, dst *T) error {
+	if value == "" {
+		return nil
+	}
+	encoded, err := column.Encode(value)
+	if err != nil {
+		return err
+	}
+	*dst = encoded.(T)
+	return nil
 }
 
 // entrypoint of running the query, divides limited slice into 4 workspaces of 500 elements each
 // each worker will run on this workspace and processes each block independently, each worker uses
 // the first 250 elements as read space to load data and the next 250 elements as write space
-// to store filter or load results
-func (q *QueryRunner) RunQuery() []float64 {
+// to store filter or load results, fails if a block can't be read
+func (q *QueryRunner) RunQuery() ([]float64, error) {
 	// start workers
 	workerSpaceSize := 500
 	for i := 0; i < 4; i++ {
@@ -90,8 +200,11 @@ func (q *QueryRunner) RunQuery() []float64 {
 	// close the channel and wait until all workers are done
 	close(q.TaskQueue)
 	q.wg.Wait()
+	if q.err != nil {
+		return nil, q.err
+	}
 
-	return q.formatResults()
+	return q.formatResults(), nil
 }
 
 // starts a worker, it consumes qualified blocks from the channel and processes it
@@ -104,10 +217,10 @@ func (q *QueryRunner) startWorker(workerIdx int, workerSpace int) {
 			// run appropriate process depending on the query type
 			var done bool
 			switch query := query.(type) {
-			case *RangeFilterQuery[int8], *RangeFilterQuery[float64], *ExactFilterQuery:
+			case Filter:
 				done = q.handleFilter(firstFilter, query, blockIdx, workerIdx, workerSpace)
 			case SharedScan:
-				done = q.handleSharedScan(query, blockIdx, workerIdx, workerSpace)
+				done = q.handleSharedScan(firstFilter, query, blockIdx, workerIdx, workerSpace)
 			case *Operation:
 				done = q.handleOperation(query, blockIdx, workerIdx, workerSpace)
 			}
@@ -122,7 +235,7 @@ func (q *QueryRunner) startWorker(workerIdx int, workerSpace int) {
 // perform filtering, first laod data into the read space and write booleans into the write space, treating the write space
 // as a bit map for inidcating whether index of data in the data block qualifies or not, data bocks are 250 wide so its 
 // guaranteed to fit in the read space
-func (q *QueryRunner) handleFilter(isFirstFilter bool, query any, blockIdx, workerIdx, workerSpace int) bool {
+func (q *QueryRunner) handleFilter(isFirstFilter bool, query Filter, blockIdx, workerIdx, workerSpace int) bool {
 	// split the 500 element workerSpace into read and write space of 250 elements each
 	readStart := workerIdx
 	readEnd := workerIdx + workerSpace/2 - 1
@@ -130,41 +243,14 @@ func (q *QueryRunner) handleFilter(isFirstFilter bool, query any, blockIdx, work
 	writeEnd := writeStart + workerSpace/2 - 1
 	rwSpace := workerSpace / 2
 
-	// init reader appropriately based on query column type and store results of index checks to handle afterwards
-	var skippable, qualified bool
-	var reader custom.Reader
-	var limitByte int64
-	switch query := query.(type) {
-	case *RangeFilterQuery[int8]:
-		filePath := fmt.Sprintf("column_store/rle_%s", query.Column.Name)
-		offsetByte := query.Column.OffsetMapIndex[blockIdx]
-		if blockIdx+1 < len(query.Column.OffsetMapIndex) {
-			limitByte = query.Column.OffsetMapIndex[blockIdx+1]
-		} else {
-			limitByte = -1
-		}
-		skippable, qualified = query.Column.ZoneMapIndexInt8[blockIdx].Check(query.InclusiveMin, query.InclusiveMax)
-		reader = custom.NewReader(filePath, offsetByte, limitByte, q.LimitedSlice, custom.FromBinaryInt8)
-	case *RangeFilterQuery[float64]:
-		filePath := fmt.Sprintf("column_store/rle_%s", query.Column.Name)
-		offsetByte := query.Column.OffsetMapIndex[blockIdx]
-		if blockIdx+1 < len(query.Column.OffsetMapIndex) {
-			limitByte = query.Column.OffsetMapIndex[blockIdx+1]
-		} else {
-			limitByte = -1
-		}
-		skippable, qualified = query.Column.ZoneMapIndexFloat64[blockIdx].Check(query.InclusiveMin, query.InclusiveMax)
-		reader = custom.NewReader(filePath, offsetByte, limitByte, q.LimitedSlice, custom.FromBinaryFloat64)
-	case *ExactFilterQuery:
-		filePath := fmt.Sprintf("column_store/rle_%s", query.Column.Name)
-		offsetByte := query.Column.OffsetMapIndex[blockIdx]
-		if blockIdx+1 < len(query.Column.OffsetMapIndex) {
-			limitByte = query.Column.OffsetMapIndex[blockIdx+1]
-		} else {
-			limitByte = -1
-		}
-		skippable, qualified = query.Column.BitMapIndex[blockIdx].Check(query.Match)
-		reader = custom.NewReader(filePath, offsetByte, limitByte, q.LimitedSlice, custom.FromBinaryInt8)
+	// init reader based on the query column type and check the indexes of the block, columns without the index
+	// can't be skipped
+	column := query.filterColumn()
+	skippable, qualified := query.checkIndexes(blockIdx)
+
+	// NULLs never qualify, so blocks with NULLs can't be taken whole
+	if skippable && qualified && column.HasNulls(blockIdx) {
+		skippable = false
 	}
 
 	// check indexes using the previously stored results
@@ -175,7 +261,7 @@ func (q *QueryRunner) handleFilter(isFirstFilter bool, query any, blockIdx, work
 				return false
 			}
 			// else fill the writer buffer with all rows as valid
-			for i := writeStart; i < writeEnd; i++ {
+			for i := writeStart; i <= writeEnd; i++ {
 				q.LimitedSlice.Set(i, true)
 			}
 			return false
@@ -187,42 +273,34 @@ func (q *QueryRunner) handleFilter(isFirstFilter bool, query any, blockIdx, work
 
 	// index unable to determine valid rows, so we load data and filter manually
 	hasValidRows := false
-	readCnt := reader.ReadTo(readStart, readEnd)
+	readCnt, ok := q.readBlock(column, blockIdx, readStart, readEnd)
+	if !ok {
+		return true
+	}
 	prevRunLen := 0 // helps to write to the write index at the write space
 	for i := readStart; i < readStart+readCnt; i++ {
-		val := q.LimitedSlice.Get(i)
-		// check if its an RLE run, and handle appropriately
-		if length, isRun := utils.CheckRunLength(val); isRun {
-			runVal := q.LimitedSlice.Get(i + 1)
-			for j := range length {
-				// if qualified and previous row also qualifies or is the first filter set the row bit map to true
-				if evaluateFilter(query, runVal) && (isFirstFilter || q.LimitedSlice.Get(i+rwSpace+prevRunLen+j) != nil) {
-					q.LimitedSlice.Set(i+rwSpace+prevRunLen+j, true)
-					hasValidRows = true
-				} else { // unqualified row, so reset the index
-					q.LimitedSlice.Set(i+rwSpace+prevRunLen+j, nil)
-				}
+		// single values are handled as runs of 1
+		run, _ := utils.CheckRun(q.LimitedSlice.Get(i))
+		for j := range run.Length {
+			// if qualified and previous row also qualifies or is the first filter set the row bit map to true
+			writerIdx := i + rwSpace + prevRunLen + j
+			if evaluateFilter(query, rowValue(column, blockIdx, i-readStart+prevRunLen+j, run.Value)) && (isFirstFilter || q.LimitedSlice.Get(writerIdx) != nil) {
+				q.LimitedSlice.Set(writerIdx, true)
+				hasValidRows = true
+			} else { // unqualified row, so reset the index
+				q.LimitedSlice.Set(writerIdx, nil)
 			}
-			prevRunLen += length - 2
-			i += 1
-			continue
-		}
-
-		// not an RLE run so continue as normal
-		if evaluateFilter(query, val) && (isFirstFilter || q.LimitedSlice.Get(i+prevRunLen+rwSpace) != nil) {
-			q.LimitedSlice.Set(i+prevRunLen+rwSpace, true)
-			hasValidRows = true
-		} else {
-			q.LimitedSlice.Set(i+rwSpace+prevRunLen, nil)
 		}
+		prevRunLen += run.Length - 1
 	}
 
 	return !hasValidRows
 }
 
 // handle shared scans, which are aggregate queries, there are 2 types aggregates on a column and on existing data
-// for aggregates on a column, first laod the data from disk, otherwise directly read the existing data and compute results
-func (q *QueryRunner) handleSharedScan(sharedScan SharedScan, blockIdx, workerIdx, workerSpace int) bool {
+// for aggregates on a column, first laod the data from disk, otherwise directly read the existing data and compute results,
+// if no filter ran before the scan every row of the block qualifies
+func (q *QueryRunner) handleSharedScan(noFilter bool, sharedScan SharedScan, blockIdx, workerIdx, workerSpace int) bool {
 	readStart := workerIdx
 	readEnd := workerIdx + workerSpace/2 - 1
 	writeStart := readEnd + 1
@@ -232,43 +310,36 @@ func (q *QueryRunner) handleSharedScan(sharedScan SharedScan, blockIdx, workerId
 	// if aggregate query is on a specific column load the data and decode with RLE first, in cases like minimum
 	// price per area where the query is after an operation, perform the aggregate directly without loading any data
 	query := sharedScan[0].(*MinQuery)
+	if noFilter {
+		for i := writeStart; i <= writeEnd; i++ {
+			q.LimitedSlice.Set(i, true)
+		}
+	}
 	if query.Column != nil {
-		// intiialize reader
-		filePath := fmt.Sprintf("column_store/rle_%s", query.Column.Name)
-		offsetByte := query.Column.OffsetMapIndex[blockIdx]
-		var limitByte int64
-		if blockIdx+1 < len(query.Column.OffsetMapIndex) {
-			limitByte = query.Column.OffsetMapIndex[blockIdx+1]
-		} else {
-			limitByte = -1
+		readCnt, ok := q.readBlock(query.Column, blockIdx, readStart, readEnd)
+		if !ok {
+			return true
 		}
-		reader := custom.NewReader(filePath, offsetByte, limitByte, q.LimitedSlice, custom.FromBinaryFloat64)
-		readCnt := reader.ReadTo(readStart, readEnd)
 
 		// perform run length decoding and write valid data to the write space, only write to indexes
-		// which are valid, i.e. data is non null, this is to make sure that we only laod data rows which
-		// are valid after filtering, which maye be done in previous steps
+		// which are valid, i.e. data is non nil, this is to make sure that we only laod data rows which
+		// are valid after filtering, which maye be done in previous steps, NULL values are written as
+		// data.Null so the row stays valid
 		prevRunLen := 0
 		for i := readStart; i < readStart+readCnt; i++ {
-			val := q.LimitedSlice.Get(i)
-			// check if its an RLE run, and handle appropriately
-			if length, isRun := utils.CheckRunLength(val); isRun {
-				runVal := q.LimitedSlice.Get(i + 1)
-				for j := range length {
-					if q.LimitedSlice.Get(i+rwSpace+prevRunLen+j) != nil {
-						q.LimitedSlice.Set(i+rwSpace+prevRunLen+j, runVal)
-					}
+			// single values are handled as runs of 1
+			run, _ := utils.CheckRun(q.LimitedSlice.Get(i))
+			for j := range run.Length {
+				writerIdx := i + rwSpace + prevRunLen + j
+				if q.LimitedSlice.Get(writerIdx) != nil {
+					q.LimitedSlice.Set(writerIdx, rowValue(query.Column, blockIdx, i-readStart+prevRunLen+j, run.Value))
 				}
-				prevRunLen += length - 2
-				i += 1
-				continue
-			}
-
-			// not RLE run so continue normally
-			if q.LimitedSlice.Get(i+prevRunLen+rwSpace) != nil {
-				q.LimitedSlice.Set(i+prevRunLen+rwSpace, val)
 			}
+			prevRunLen += run.Length - 1
 		}
+
+		// the last block can be shorter than the write space, rows past its end don't exist
+		q.LimitedSlice.Reset(readStart+readCnt+prevRunLen+rwSpace, writeEnd)
 	}
 
 	// perform shared scan on the valid loaded data
@@ -291,47 +362,54 @@ func (q *QueryRunner) handleOperation(operation *Operation, blockIdx, workerIdx,
 	readEnd := workerIdx + workerSpace/2 - 1
 	rwSpace := workerSpace / 2
 
-	// initialize reader
-	filePath := fmt.Sprintf("column_store/rle_%s", operation.Column.Name)
-	offsetByte := operation.Column.OffsetMapIndex[blockIdx]
-	var limitByte int64
-	if blockIdx+1 < len(operation.Column.OffsetMapIndex) {
-		limitByte = operation.Column.OffsetMapIndex[blockIdx+1]
-	} else {
-		limitByte = -1
-	}
-	reader := custom.NewReader(filePath, offsetByte, limitByte, q.LimitedSlice, custom.FromBinaryFloat64)
-
 	// read values and perform operation only if the row is valid
-	readCnt := reader.ReadTo(readStart, readEnd)
+	readCnt, ok := q.readBlock(operation.Column, blockIdx, readStart, readEnd)
+	if !ok {
+		return true
+	}
 	prevRunLen := 0
 	for i := readStart; i < readStart+readCnt; i++ {
-		val := q.LimitedSlice.Get(i)
-		if length, isRun := utils.CheckRunLength(val); isRun { // is RLE run so stay in this idx and handle it
-			runVal := q.LimitedSlice.Get(i + 1)
-			for j := range length {
-				writerIdx := i + rwSpace + prevRunLen + j
-				if q.LimitedSlice.Get(writerIdx) != nil {
-					current := q.LimitedSlice.Get(writerIdx)
-					q.LimitedSlice.Set(writerIdx, operation.Op.compute(current.(float64), runVal.(float64)))
-				}
+		// single values are handled as runs of 1
+		run, _ := utils.CheckRun(q.LimitedSlice.Get(i))
+		for j := range run.Length {
+			writerIdx := i + rwSpace + prevRunLen + j
+			if q.LimitedSlice.Get(writerIdx) != nil {
+				current := q.LimitedSlice.Get(writerIdx)
+				val := rowValue(operation.Column, blockIdx, i-readStart+prevRunLen+j, run.Value)
+				q.LimitedSlice.Set(writerIdx, operation.Op.apply(current, val))
 			}
-			prevRunLen += length - 2
-			i += 1
-			continue
-		}
-
-		// not RLE so continue as normal
-		writerIdx := i + prevRunLen + rwSpace
-		if q.LimitedSlice.Get(writerIdx) != nil {
-			current := q.LimitedSlice.Get(writerIdx)
-			q.LimitedSlice.Set(writerIdx, operation.Op.compute(current.(float64), val.(float64)))
 		}
+		prevRunLen += run.Length - 1
 	}
 
 	return false
 }
 
+// read a single block of a column file to the limited slice from start to end, the segments and offset map give the
+// column file and byte range of the block, on failure the error is kept for RunQuery and false is returned
+func (q *QueryRunner) readBlock(column *data.Metadata, blockIdx, start, end int) (int, bool) {
+	file, offsetByte, limitByte := column.BlockLocation(blockIdx)
+	reader, err := custom.NewColumnReader(filepath.Join(q.ColumnStoreDir, file), column, blockIdx, offsetByte, limitByte, q.LimitedSlice)
+	if err != nil {
+		q.errOnce.Do(func() { q.err = err })
+		return 0, false
+	}
+	readCnt := reader.ReadTo(start, end)
+	if err := reader.Err(); err != nil {
+		q.errOnce.Do(func() { q.err = err })
+		return 0, false
+	}
+	return readCnt, true
+}
+
+// value of a row of the block, NULL if the validity bit map of the block says so
+func rowValue(column *data.Metadata, blockIdx, row int, val any) any {
+	if column.IsNull(blockIdx, row) {
+		return data.Null
+	}
+	return val
+}
+
 // checks the query plan for SharedScan type and extracts the query results
 func (q *QueryRunner) formatResults() []float64 {
 	res := []float64{}
@@ -9,30 +9,33 @@ type CsvDataWithIdx struct {
 }
 
 // heap for merge step in external sort, implements the heap interface in Go stdlib
-type DataHeap []CsvDataWithIdx
+type DataHeap struct {
+	Items  []CsvDataWithIdx
+	Schema *data.Schema // provides the sort key to order rows on
+}
 
-func (pq DataHeap) Len() int { return len(pq) }
+func (pq DataHeap) Len() int { return len(pq.Items) }
 
-// sort based on month, we can use the dictionary encoding as it is monotonic
+// sort based on the sort key of the schema
 func (pq DataHeap) Less(i, j int) bool {
-	return data.MonthToInt[pq[i].Data.Month] < data.MonthToInt[pq[j].Data.Month]
+	return pq.Schema.Less(pq.Items[i].Data, pq.Items[j].Data)
 }
 
 func (pq DataHeap) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
+	pq.Items[i], pq.Items[j] = pq.Items[j], pq.Items[i]
 }
 
 // push data to the heap
 func (pq *DataHeap) Push(v any) {
 	item := v.(CsvDataWithIdx)
-	*pq = append(*pq, item)
+	pq.Items = append(pq.Items, item)
 }
 
-// pops data with the smallest month value
+// pops data with the smallest sort key value
 func (pq *DataHeap) Pop() any {
-	old := *pq
+	old := pq.Items
 	n := len(old)
 	item := old[n-1]
-	*pq = old[0 : n-1]
+	pq.Items = old[0 : n-1]
 	return item
 }
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sc4023/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// file marking a column store as completely built, it is written last so a store without it is never queried
+const CompletionMarker = "COMPLETE"
+
+// column stores are published as versions, directories next to dir named <dir>.v<n>, and dir is a symbolic link to the
+// current version, so publishing a new version is one atomic rename of a new link over dir and queries read every file
+// from the version dir linked to when they started
+func versionDir(dir string, version int) string {
+	return fmt.Sprintf("%s.v%d", filepath.Clean(dir), version)
+}
+
+// remove what a previous interrupted initialization or append left next to dir and return an empty directory for the
+// next version of the column store
+func PrepareStagingDir(dir string) (string, error) {
+	if err := recoverStore(dir); err != nil {
+		return "", err
+	}
+	current := currentVersion(dir)
+	versions, err := storeVersions(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// versions after the current one were never linked to
+	for _, version := range versions {
+		if version > current {
+			if err := os.RemoveAll(versionDir(dir, version)); err != nil {
+				return "", fmt.Errorf("failed to remove %s: %w", versionDir(dir, version), err)
+			}
+		}
+	}
+	stagingDir := versionDir(dir, max(current, 0)+1)
+	if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", stagingDir, err)
+	}
+	return stagingDir, nil
+}
+
+// prepare a staging directory holding the version storeDir of the column store at dir for an append, the
+// rle_<column_name> files and the rejects csv appends write to are copied and every other file is hard linked as it
+// never changes, the catalog and the dict_<column_name> dictionaries are left out as the append writes new ones
+func PrepareAppendDir(dir string, storeDir string) (string, error) {
+	stagingDir, err := PrepareStagingDir(dir)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(storeDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		src, dst := filepath.Join(storeDir, name), filepath.Join(stagingDir, name)
+		switch {
+		case entry.IsDir() || name == CompletionMarker || name == "catalog.json" || strings.HasPrefix(name, "dict_"):
+			continue
+		case strings.HasPrefix(name, "sorted") || strings.HasPrefix(name, "raw_"):
+			// intermediates still in versions initialized before they were removed
+			continue
+		case strings.HasPrefix(name, "rle_") || name == "rejects.csv":
+			err = copyFile(src, dst)
+		default:
+			err = os.Link(src, dst)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stage %s: %w", name, err)
+		}
+	}
+	return stagingDir, nil
+}
+
+// fsync the column store built in the staging directory, mark it complete, and atomically link dir to it, the previous
+// version and versions leased by queries still reading them are kept and older versions are removed
+func Publish(stagingDir, dir string) error {
+	if err := utils.SyncDir(stagingDir); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(stagingDir, CompletionMarker), nil, 0644); err != nil {
+		return fmt.Errorf("failed to write completion marker: %w", err)
+	}
+	if err := utils.SyncDir(stagingDir); err != nil {
+		return err
+	}
+
+	// a column store built before versions is a directory, it becomes version 0, a crash before it is linked to again
+	// is recovered by recoverStore
+	previous := currentVersion(dir)
+	if info, err := os.Lstat(dir); err == nil && info.IsDir() {
+		if err := os.RemoveAll(versionDir(dir, 0)); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", versionDir(dir, 0), err)
+		}
+		if err := os.Rename(dir, versionDir(dir, 0)); err != nil {
+			return fmt.Errorf("failed to move aside %s: %w", dir, err)
+		}
+		previous = 0
+	}
+	if err := linkVersion(dir, stagingDir); err != nil {
+		return err
+	}
+
+	versions, err := storeVersions(dir)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version != previous && versionDir(dir, version) != filepath.Clean(stagingDir) {
+			if err := removeVersion(versionDir(dir, version)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check that the column store at dir was completely built and return the directory of its current version, every file
+// of a query is read from it so a column store published while the query runs doesn't mix with it, the version is
+// leased until release is called so publishes don't remove it meanwhile
+func CheckComplete(dir string) (string, func(), error) {
+	for {
+		if err := recoverStore(dir); err != nil {
+			return "", nil, err
+		}
+		storeDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return "", nil, fmt.Errorf("column store in %s is missing or incomplete", dir)
+		}
+		release, err := leaseVersion(storeDir)
+		if err == nil {
+			return storeDir, release, nil
+		}
+
+		// the version was removed by a publish after dir was resolved, dir links to a newer version then
+		if current, err := filepath.EvalSymlinks(dir); err != nil || current == storeDir {
+			return "", nil, fmt.Errorf("column store in %s is missing or incomplete", dir)
+		}
+	}
+}
+
+// take a shared lock on the completion marker of a version, a version is only removed with an exclusive lock on it
+func leaseVersion(storeDir string) (func(), error) {
+	marker, err := os.Open(filepath.Join(storeDir, CompletionMarker))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(marker.Fd()), syscall.LOCK_SH); err != nil {
+		marker.Close()
+		return nil, fmt.Errorf("failed to lease %s: %w", storeDir, err)
+	}
+
+	// the version might have been removed before the lock was taken
+	if _, err := os.Stat(marker.Name()); err != nil {
+		marker.Close()
+		return nil, err
+	}
+	return func() { marker.Close() }, nil
+}
+
+// remove a version unless a query leased it, it is then removed by a later publish
+func removeVersion(storeDir string) error {
+	marker, err := os.Open(filepath.Join(storeDir, CompletionMarker))
+	if err == nil {
+		defer marker.Close()
+		err = syscall.Flock(int(marker.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", storeDir, err)
+		}
+	}
+	if err := os.RemoveAll(storeDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", storeDir, err)
+	}
+	return nil
+}
+
+// link dir to the latest complete version if dir is missing, e.g. after a crash while a column store built before
+// versions was replaced
+func recoverStore(dir string) error {
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		return nil
+	}
+	versions, err := storeVersions(dir)
+	if err != nil {
+		return err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if _, err := os.Stat(filepath.Join(versionDir(dir, versions[i]), CompletionMarker)); err == nil {
+			return linkVersion(dir, versionDir(dir, versions[i]))
+		}
+	}
+	return nil
+}
+
+// atomically replace the link at dir with a link to the version directory, the link is relative so the column store
+// can be moved with its versions
+func linkVersion(dir string, storeDir string) error {
+	link := filepath.Clean(dir) + ".link"
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", link, err)
+	}
+	if err := os.Symlink(filepath.Base(storeDir), link); err != nil {
+		return fmt.Errorf("failed to link %s: %w", storeDir, err)
+	}
+	if err := os.Rename(link, dir); err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w", dir, storeDir, err)
+	}
+	return utils.SyncFile(filepath.Dir(filepath.Clean(dir)))
+}
+
+// version dir links to, -1 if dir is missing or a column store built before versions
+func currentVersion(dir string) int {
+	target, err := os.Readlink(dir)
+	if err != nil {
+		return -1
+	}
+	version, ok := parseVersion(dir, filepath.Base(target))
+	if !ok {
+		return -1
+	}
+	return version
+}
+
+// versions of the column store at dir, in ascending order
+func storeVersions(dir string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Dir(filepath.Clean(dir)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	versions := []int{}
+	for _, entry := range entries {
+		if version, ok := parseVersion(dir, entry.Name()); ok && entry.IsDir() {
+			versions = append(versions, version)
+		}
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
+
+// version of the version directory name of the column store at dir
+func parseVersion(dir string, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, filepath.Base(filepath.Clean(dir))+".v")
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(suffix)
+	return version, err == nil && version >= 0
+}
+
+// copy the content of src to a new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
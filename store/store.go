@@ -2,98 +2,603 @@ package store
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sc4023/custom"
 	"sc4023/data"
 	"sc4023/utils"
-	"strconv"
+	"slices"
+	"sync"
 )
 
 type Store struct {
 	LimitedSlice        custom.LimitedSlice // limited buffer, all operations must happen here without external allocations
-	DataPath            string              // path of raw csv
-	SortedChunkDataPath string              // path of csv with sorted chunks (on month)
-	SortedDataPath      string              // path of final sorted csv (on month)
+	ColumnStoreDir      string              // directory where column files are written
+	DataPaths           []string            // paths or globs of the raw csvs, read as one input, .gz files are decompressed
+	Stdin               io.Reader           // input read for the utils.StdinPath data path, os.Stdin if nil
+	SortedChunkDataPath string              // path prefix of the binary row files with sorted chunks (on sort key), one per sort worker
+	SortedDataPath      string              // path of final sorted binary row file (on sort key)
+	CatalogPath         string              // path of the catalog where metadata is persisted for later queries
+	Schema              *data.Schema        // schema of the raw csv
 	ColumnStoreMetadata data.Metadatas      // metadata of each column store column
+	MergeFanIn          int                 // maximum number of sorted runs merged at once, DefaultMergeFanIn if 0
+	SortWorkers         int                 // number of goroutines generating sorted runs, DefaultSortWorkers if 0
+	RejectsPath         string              // path of the csv the rejected rows of DataPaths are added to, only counted if empty
+	MaxRejectRatio      float64             // fraction of the rows of DataPaths that may be rejected before initialization aborts
+	KeepIntermediates   bool                // keep the sorted rows and the raw columns in ColumnStoreDir, e.g. to check them
 }
 
-func (s Store) InitColumnStore() {
-	// sort every chunk of DataPath and write to SortedChunkDataPath, returns byte offset of every chunk
-	chunkByteOffset := s.sortChunks()
+// defaults of the external sort, the 120 chunks the workers sort ResalePricesSingapore.csv into are merged in one pass
+const (
+	DefaultMergeFanIn  = 128
+	DefaultSortWorkers = 4
+)
+
+// byte range of a sorted run in a run file
+type sortedRun struct {
+	File  string
+	Start int64
+	End   int64
+}
+
+// number of rows of DataPaths read and rejected by the sort workers
+type rowCounts struct {
+	Read     int
+	Rejected int
+}
+
+// record aligned part of a raw csv sorted by a sort worker
+type csvPart struct {
+	Path   string
+	Header utils.CsvHeader // header of the raw csv
+	Start  int64           // byte offset the part starts at, in the decompressed data of gzipped csvs
+	End    int64           // byte offset the part ends at, -1 for the end of the raw csv
+	Line   int             // number of lines before Start
+}
+
+func (s Store) InitColumnStore() error {
+	if err := s.checkSortLimits(); err != nil {
+		return err
+	}
+
+	// cut the columns the rows are clustered on along a z-order curve into ranges, appends reuse them
+	if len(s.Schema.ZOrder) > 0 {
+		if err := s.findZOrderBounds(); err != nil {
+			return err
+		}
+	}
+
+	// sort every chunk of DataPaths and write to the run files of the workers, returns byte range of every chunk
+	runs, counts, err := s.sortChunks()
+	if err != nil {
+		return err
+	}
+	total := counts.Read + counts.Rejected
+	if counts.Rejected > 0 && float64(counts.Rejected) > s.MaxRejectRatio*float64(total) {
+		return fmt.Errorf("rejected %d of %d rows, more than the maximum reject ratio %g, see %s", counts.Rejected, total,
+			s.MaxRejectRatio, s.RejectsPath)
+	}
 
 	// merge sorted chunks to SortedDataPath
-	s.mergeSortedChunks(chunkByteOffset)
+	if err := s.mergeSortedChunks(runs); err != nil {
+		return err
+	}
 
-	// load sorted columns and write each columns to separate files
-	s.separateColumns()
+	// load sorted columns and write each columns to separate files, building dictionaries along the way
+	if err := s.separateColumns("raw_"); err != nil {
+		return err
+	}
 
 	// for all columns compress using run length encoding
 	// for relevant columns compute indexes (zone map, bit map, and/or offset map)
-	s.processColumns()
+	if err := s.processColumns("raw_", false); err != nil {
+		return err
+	}
+	if err := s.removeIntermediates("raw_"); err != nil {
+		return err
+	}
+
+	// persist metadata, dictionaries, and indexes so later runs can query without initializing again
+	if err := data.SaveCatalog(s.CatalogPath, s.Schema, s.ColumnStoreMetadata); err != nil {
+		return err
+	}
+	s.printRejects(counts)
+	return nil
 }
 
-// sort every 2000 rows and write to SortedChunkDataPath, this is the first step for external sort
-func (s Store) sortChunks() []int64 {
-	headerByte := utils.CountHeaderByte(s.DataPath) // skip raw csv data header
-	reader := custom.NewReader(s.DataPath, int64(headerByte), -1, s.LimitedSlice, custom.FromCsv)
-	writer := custom.NewWriter(s.SortedChunkDataPath, s.LimitedSlice, custom.ToCsv)
+// append the rows of DataPaths to an initialized column store, the rows are sorted like during initialization, if they
+// don't sort before the last row of the column store they are appended as new blocks to the rle_<column_name> files,
+// otherwise they are written to a new delta segment, the indexes of the new blocks extend the indexes of the catalog
+// so queries see the new rows right away, the intermediate files of the append are removed afterwards
+func (s Store) AppendColumnStore() error {
+	if err := s.checkSortLimits(); err != nil {
+		return err
+	}
+	runs, counts, err := s.sortChunks()
+	if err != nil {
+		return err
+	}
+	if err := s.mergeSortedChunks(runs); err != nil {
+		return err
+	}
+	inOrder, err := s.appendsInOrder()
+	if err != nil {
+		return err
+	}
 
-	chunkByteOffset := []int64{} // used to indicate byte offsets of sorted chunk for the merge step
-	for {
-		chunkByteOffset = append(chunkByteOffset, reader.GetByteOffset()-int64(headerByte))
+	// dictionaries are extended with the new distinct values, existing blocks are written again if codes change
+	if err := s.separateColumns("append_raw_"); err != nil {
+		return err
+	}
+	if err := s.processColumns("append_raw_", !inOrder); err != nil {
+		return err
+	}
 
-		// laod data and sort every 2000 data chunk
+	if err := s.removeIntermediates("append_raw_"); err != nil {
+		return err
+	}
+	if err := data.SaveCatalog(s.CatalogPath, s.Schema, s.ColumnStoreMetadata); err != nil {
+		return err
+	}
+	s.printRejects(counts)
+	return nil
+}
+
+// remove the sorted rows, the run files of the sort workers, and the <rawPrefix><column_name> files once the columns
+// are written, so they are neither published nor linked into every later version, unless KeepIntermediates
+func (s Store) removeIntermediates(rawPrefix string) error {
+	if s.KeepIntermediates {
+		return nil
+	}
+	intermediates := []string{s.SortedDataPath}
+	for w := range s.sortWorkers() {
+		intermediates = append(intermediates, s.chunkRunFile(w))
+	}
+	for _, metadata := range s.ColumnStoreMetadata {
+		intermediates = append(intermediates, filepath.Join(s.ColumnStoreDir, rawPrefix+metadata.Name))
+	}
+	for _, path := range intermediates {
+		// workers without a part of the rows never create their run file
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// whether the sorted rows at SortedDataPath don't sort before the last row of the rle_<column_name> files, which is
+// read from the column files of the sort key, without a sort key rows can be appended in any order
+func (s Store) appendsInOrder() (bool, error) {
+	if len(s.Schema.SortKey) == 0 {
+		return true, nil
+	}
+	reader := custom.NewRowReader(s.SortedDataPath, 0, -1, s.LimitedSlice, s.Schema)
+	if reader.ReadTo(0, 0) == 0 {
+		return true, reader.Err()
+	}
+	first := s.LimitedSlice.Get(0).(data.CsvData)
+
+	// compare raw values as the sorted rows are not encoded yet, NULLs are stored as zero values so a NULL last row might
+	// send the rows to a delta segment needlessly but never appends them out of order
+	lastRow := slices.Clone(first)
+	for col, metadata := range s.ColumnStoreMetadata {
+		if !slices.Contains(s.Schema.SortKey, metadata.Name) {
+			continue
+		}
+		if metadata.OffsetMapIndex == nil {
+			return false, fmt.Errorf("sort key column %s has no offset map", metadata.Name)
+		}
+		last, err := s.lastMainValue(metadata)
+		if err != nil {
+			return false, err
+		}
+		if last == nil {
+			return true, nil
+		}
+		lastRow[col] = last
+	}
+	return !s.Schema.Less(first, lastRow), nil
+}
+
+// last value of the main column file of a column as a raw value, nil if the column file has no blocks
+func (s Store) lastMainValue(metadata *data.Metadata) (any, error) {
+	// the last row of the main column file is the last value of its last block, blocks of delta segments can come after
+	// it, so the block is read on its own from its offset with its index among all blocks of the column
+	var file string
+	var offset, limit int64
+	lastBlock := metadata.NumBlocks - 1
+	for ; lastBlock >= 0; lastBlock-- {
+		if file, offset, limit = metadata.BlockLocation(lastBlock); file == metadata.MainFile() {
+			break
+		}
+	}
+	if lastBlock == -1 {
+		return nil, nil
+	}
+	reader, err := custom.NewColumnReader(filepath.Join(s.ColumnStoreDir, metadata.MainFile()), metadata, lastBlock,
+		offset, limit, s.LimitedSlice)
+	if err != nil {
+		return nil, err
+	}
+	var last any
+	for {
 		readCnt := reader.ReadTo(0, s.LimitedSlice.GetLimit()-1)
-		s.LimitedSlice.Sort(0, readCnt-1, func(i, j int) bool {
-			return data.MonthToInt[s.LimitedSlice.Get(i).(data.CsvData).Month] < data.MonthToInt[s.LimitedSlice.Get(j).(data.CsvData).Month]
-		})
+		if readCnt == 0 {
+			break
+		}
+		last = s.LimitedSlice.Get(readCnt - 1)
+	}
+	if err := reader.Err(); err != nil || last == nil {
+		return nil, err
+	}
+
+	run, _ := utils.CheckRun(last)
+	if metadata.DictionaryEncode {
+		return metadata.Dictionary.Value(data.CodeIdx(run.Value)), nil
+	}
+	return run.Value, nil
+}
+
+// read DataPaths once and cut every z-order column into ranges holding about the same number of rows, like dictionaries
+// we assume the distinct values of the columns are much smaller than the data, so they are counted directly in memory
+func (s Store) findZOrderBounds() error {
+	cols := make([]int, len(s.Schema.ZOrder))
+	keyCounts := make([]map[uint64]int, len(s.Schema.ZOrder))
+	for i, name := range s.Schema.ZOrder {
+		cols[i] = slices.IndexFunc(s.Schema.Columns, func(c data.ColumnSchema) bool { return c.Name == name })
+		keyCounts[i] = map[uint64]int{}
+	}
+
+	paths, err := utils.ExpandDataPaths(s.DataPaths)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(paths, utils.StdinPath) {
+		return fmt.Errorf("z-order clustering reads the rows twice, which standard input can't be")
+	}
+	for _, path := range paths {
+		header, err := utils.ReadCsvHeader(path, s.Schema)
+		if err != nil {
+			return err
+		}
+		reader := custom.NewCsvReader(path, header.DataOffset, -1, s.LimitedSlice, s.Schema, header)
+		if reader == nil {
+			return fmt.Errorf("failed to read %s", path)
+		}
+		for {
+			readCnt := reader.ReadTo(0, s.LimitedSlice.GetLimit()-1)
+			if readCnt == 0 {
+				break
+			}
+			for row := range readCnt {
+				csvData := s.LimitedSlice.Get(row).(data.CsvData)
+				for i, col := range cols {
+					if csvData[col] != data.Null {
+						keyCounts[i][data.ZOrderKey(csvData[col])] += 1
+					}
+				}
+			}
+		}
+	}
+
+	s.Schema.ZOrderBounds = make([][]uint64, len(cols))
+	for i := range cols {
+		s.Schema.ZOrderBounds[i] = data.NewZOrderBounds(keyCounts[i], len(cols))
+	}
+	return nil
+}
+
+// split DataPaths into record aligned parts, every plain csv is split into parts of about the same size, about SortWorkers
+// parts over all plain csvs, and every gzipped csv and standard input is one part as they can only be read from the
+// start, every raw csv has its own header, the header of standard input is read by the worker sorting it
+func (s Store) splitInput() ([]csvPart, error) {
+	paths, err := utils.ExpandDataPaths(s.DataPaths)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int64, len(paths))
+	totalSize := int64(0)
+	for i, path := range paths {
+		if path == utils.StdinPath || utils.IsGzip(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = info.Size()
+		totalSize += sizes[i]
+	}
+
+	parts := []csvPart{}
+	for i, path := range paths {
+		if path == utils.StdinPath {
+			parts = append(parts, csvPart{Path: path, End: -1})
+			continue
+		}
+		header, err := utils.ReadCsvHeader(path, s.Schema) // skip raw csv data header
+		if err != nil {
+			return nil, err
+		}
+		if utils.IsGzip(path) {
+			parts = append(parts, csvPart{Path: path, Header: header, Start: header.DataOffset, End: -1, Line: header.DataLine})
+			continue
+		}
+		numParts := max(1, int((int64(s.sortWorkers())*sizes[i]+totalSize-1)/max(totalSize, 1)))
+		bounds, lines, err := utils.SplitRecords(path, header.DataOffset, numParts, s.Schema.Dialect)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s: %w", path, err)
+		}
+		for p := range numParts {
+			parts = append(parts, csvPart{Path: path, Header: header, Start: bounds[p], End: bounds[p+1],
+				Line: header.DataLine + lines[p]})
+		}
+	}
+	return parts, nil
+}
+
+// sort every chunk of DataPaths and write it as a sorted run, this is the first step for external sort, DataPaths are
+// split into record aligned parts which SortWorkers goroutines take in turn and sort in parallel, every worker owns an
+// equal region of the limited slice, sorts a chunk the size of its region at a time, and writes its runs to its own run
+// file
+func (s Store) sortChunks() ([]sortedRun, rowCounts, error) {
+	parts, err := s.splitInput()
+	if err != nil {
+		return nil, rowCounts{}, err
+	}
+	// with fewer parts than workers, e.g. only standard input, every part is sorted in a larger region
+	workers := max(1, min(s.sortWorkers(), len(parts)))
 
-		// write back to SortedChunkDataPath
-		writer.WriteFrom(0, readCnt-1)
+	// the rows rejected in every part are written to their own file, which are added to RejectsPath in the order of
+	// the parts
+	rejectFiles := make([]string, len(parts))
+	for p := range parts {
+		if s.RejectsPath != "" {
+			rejectFiles[p] = fmt.Sprintf("%s.%d", s.RejectsPath, p)
+		}
+	}
+
+	regionSize := s.LimitedSlice.GetLimit() / workers
+	workerRuns := make([][]sortedRun, workers)
+	partCounts := make([]rowCounts, len(parts))
+	partErrs := make([]error, len(parts))
+	queue := make(chan int, len(parts))
+	for p := range parts {
+		queue <- p
+	}
+	close(queue)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				var runs []sortedRun
+				runs, partCounts[p], partErrs[p] = s.sortPart(parts[p], rejectFiles[p], w*regionSize, (w+1)*regionSize-1,
+					s.chunkRunFile(w))
+				workerRuns[w] = append(workerRuns[w], runs...)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(partErrs...); err != nil {
+		return nil, rowCounts{}, err
+	}
 
+	counts := rowCounts{}
+	for _, c := range partCounts {
+		counts.Read += c.Read
+		counts.Rejected += c.Rejected
+	}
+	if s.RejectsPath != "" {
+		if err := custom.AppendRejects(s.RejectsPath, rejectFiles); err != nil {
+			return nil, rowCounts{}, err
+		}
+	}
+	return slices.Concat(workerRuns...), counts, nil
+}
+
+// sort the rows of a part of a raw csv chunk by chunk in the region from start to end of the limited slice, and write
+// every chunk as a sorted run to runFile, rejected rows are written to rejectFile unless it is empty
+func (s Store) sortPart(part csvPart, rejectFile string, start, end int, runFile string) ([]sortedRun, rowCounts, error) {
+	reader, err := s.partReader(part)
+	if err != nil {
+		return nil, rowCounts{}, err
+	}
+	var rejects *custom.RejectWriter
+	if rejectFile != "" {
+		if rejects, err = custom.NewRejectWriter(rejectFile, part.Path); err != nil {
+			return nil, rowCounts{}, err
+		}
+		reader.Quarantine(rejects, part.Line)
+	}
+	writer := custom.NewWriter(runFile, s.LimitedSlice, custom.ToRows)
+	if writer == nil {
+		return nil, rowCounts{}, fmt.Errorf("failed to open %s", runFile)
+	}
+
+	runs := []sortedRun{} // used to indicate byte ranges of the sorted chunks for the merge step
+	counts := rowCounts{}
+	for {
+		// laod data and sort every chunk
+		readCnt := reader.ReadTo(start, end)
 		if readCnt == 0 {
 			break
 		}
+		counts.Read += readCnt
+		s.LimitedSlice.Sort(start, start+readCnt-1, func(i, j int) bool {
+			return s.Schema.Less(s.LimitedSlice.Get(start+i).(data.CsvData), s.LimitedSlice.Get(start+j).(data.CsvData))
+		})
+
+		// write back to the run file
+		run := sortedRun{File: runFile, Start: writer.GetByteOffset()}
+		if err := writer.WriteFrom(start, start+readCnt-1); err != nil {
+			return nil, rowCounts{}, fmt.Errorf("failed to write %s: %w", runFile, err)
+		}
+		run.End = writer.GetByteOffset()
+		runs = append(runs, run)
+	}
+	counts.Rejected = reader.Rejected()
+	if rejects != nil {
+		if err := rejects.Close(); err != nil {
+			return nil, rowCounts{}, err
+		}
+	}
+	return runs, counts, reader.Err()
+}
+
+// reader of the rows of a part of a raw csv, standard input is read as it comes in, its sorted chunks are spilled to
+// the run file like those of any other part
+func (s Store) partReader(part csvPart) (*custom.CsvReader, error) {
+	if part.Path != utils.StdinPath {
+		reader := custom.NewCsvReader(part.Path, part.Start, part.End, s.LimitedSlice, s.Schema, part.Header)
+		if reader == nil {
+			return nil, fmt.Errorf("failed to read %s", part.Path)
+		}
+		return reader, nil
+	}
+	stdin := s.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	return custom.NewStreamCsvReader(stdin, "standard input", s.LimitedSlice, s.Schema)
+}
+
+// merge all sorted chunks into a single file, this is the second step for external sort, the chunks are the initial
+// runs and every pass merges up to MergeFanIn runs at a time into one longer run, so each run keeps at least
+// limit/(MergeFanIn+1) rows in the limited slice however many chunks there are, the last pass writes to SortedDataPath
+func (s Store) mergeSortedChunks(runs []sortedRun) error {
+	fanIn := s.mergeFanIn()
+	passFile := ""
+	for pass := 1; len(runs) > fanIn; pass++ {
+		prevPassFile := passFile
+		passFile = fmt.Sprintf("%s.pass%d", s.SortedChunkDataPath, pass)
+		writer := custom.NewWriter(passFile, s.LimitedSlice, custom.ToRows)
+		if writer == nil {
+			return fmt.Errorf("failed to open %s", passFile)
+		}
+		passRuns := []sortedRun{}
+		for i := 0; i < len(runs); i += fanIn {
+			run := sortedRun{File: passFile, Start: writer.GetByteOffset()}
+			if err := s.mergeRuns(runs[i:min(i+fanIn, len(runs))], writer); err != nil {
+				return err
+			}
+			run.End = writer.GetByteOffset()
+			passRuns = append(passRuns, run)
+		}
+		if err := removeRunFile(prevPassFile); err != nil {
+			return err
+		}
+		runs = passRuns
 	}
-	return chunkByteOffset
+	writer := custom.NewWriter(s.SortedDataPath, s.LimitedSlice, custom.ToRows)
+	if writer == nil {
+		return fmt.Errorf("failed to open %s", s.SortedDataPath)
+	}
+	if err := s.mergeRuns(runs, writer); err != nil {
+		return err
+	}
+	return removeRunFile(passFile)
+}
+
+// check that every sort worker gets a region of the limited slice and that every run of a merge gets at least one row
+// of the limited slice besides the merged run
+func (s Store) checkSortLimits() error {
+	if workers := s.sortWorkers(); workers < 1 || workers > s.LimitedSlice.GetLimit() {
+		return fmt.Errorf("%d sort workers, must be between 1 and %d", workers, s.LimitedSlice.GetLimit())
+	}
+	if fanIn := s.mergeFanIn(); fanIn < 2 || fanIn > s.LimitedSlice.GetLimit()-1 {
+		return fmt.Errorf("merge fan-in %d must be between 2 and %d", fanIn, s.LimitedSlice.GetLimit()-1)
+	}
+	return nil
+}
+
+// number of goroutines generating sorted runs, SortWorkers or DefaultSortWorkers if it is not set
+func (s Store) sortWorkers() int {
+	if s.SortWorkers == 0 {
+		return DefaultSortWorkers
+	}
+	return s.SortWorkers
+}
+
+// maximum number of runs merged at once, MergeFanIn or DefaultMergeFanIn if it is not set
+func (s Store) mergeFanIn() int {
+	if s.MergeFanIn == 0 {
+		return DefaultMergeFanIn
+	}
+	return s.MergeFanIn
+}
+
+// print how many rows of DataPaths were loaded and rejected
+func (s Store) printRejects(counts rowCounts) {
+	if counts.Rejected == 0 || s.RejectsPath == "" {
+		fmt.Printf("Loaded %d rows, rejected %d rows\n", counts.Read, counts.Rejected)
+		return
+	}
+	fmt.Printf("Loaded %d rows, rejected %d rows, see %s in the column store\n", counts.Read, counts.Rejected,
+		filepath.Base(s.RejectsPath))
+}
+
+// remove the run file of a merge pass once the next pass has merged it, the sorted chunks are kept
+func removeRunFile(runFile string) error {
+	if runFile == "" {
+		return nil
+	}
+	if err := os.Remove(runFile); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", runFile, err)
+	}
+	return nil
+}
+
+// run file of the sorted chunks of a run generation worker
+func (s Store) chunkRunFile(worker int) string {
+	return fmt.Sprintf("%s.%d", s.SortedChunkDataPath, worker)
 }
 
-// merge all 2000 wide chunks into a single file, this is the second step for external sort
-func (s Store) mergeSortedChunks(chunkByteOffset []int64) {
-	// initialize readers based on the previous sorted chunk offsets
+// merge runs into a single run written by writer, fails if a run can't be read to its end so no rows are dropped
+func (s Store) mergeRuns(runs []sortedRun, writer custom.Writer) error {
+	// initialize readers based on the run byte ranges
 	readerIdx := []int{}
 	readers := []custom.Reader{}
-	numChunks := len(chunkByteOffset) - 1
+	numChunks := len(runs)
 	readerDataLeft := make([]int, numChunks)
 	chunkDataSize := s.LimitedSlice.GetLimit() / (numChunks + 1)
-	for i := range numChunks {
-		readerIdx = append(readerIdx, i*chunkDataSize)
-		if i == numChunks-1 {
-			readers = append(readers, custom.NewReader(s.SortedChunkDataPath, chunkByteOffset[i], -1, s.LimitedSlice, custom.FromCsv))
-		} else {
-			readers = append(readers, custom.NewReader(s.SortedChunkDataPath, chunkByteOffset[i], chunkByteOffset[i+1], s.LimitedSlice, custom.FromCsv))
+	for i, run := range runs {
+		reader := custom.NewRowReader(run.File, run.Start, run.End, s.LimitedSlice, s.Schema)
+		if reader == nil {
+			return fmt.Errorf("failed to open %s", run.File)
 		}
+		readerIdx = append(readerIdx, i*chunkDataSize)
+		readers = append(readers, reader)
 	}
 
-	// writer to final sorted file 
+	// the rest of the limited slice buffers the merged run
 	writerIdx := numChunks * chunkDataSize
-	writer := custom.NewWriter(s.SortedDataPath, s.LimitedSlice, custom.ToCsv)
 
-	// initialize heap with the first data of every sorted chunk
-	h := DataHeap{}
+	// initialize heap with the first data of every sorted chunk, empty runs are left out
+	h := DataHeap{Schema: s.Schema}
 	for i, r := range readers {
 		readCnt := r.ReadTo(readerIdx[i], readerIdx[i]+chunkDataSize-1)
+		if err := r.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", runs[i].File, err)
+		}
+		if readCnt == 0 {
+			continue
+		}
 		readerDataLeft[i] = readCnt - 1
-		h = append(h, CsvDataWithIdx{Data: s.LimitedSlice.Get(readerIdx[i]).(data.CsvData), Idx: i})
+		h.Items = append(h.Items, CsvDataWithIdx{Data: s.LimitedSlice.Get(readerIdx[i]).(data.CsvData), Idx: i})
 	}
 
 	// until the heap is empty perform the following:
 	// 1. when chunk buffer is empty load data from file starting from ChunkByteOffset (stored inside reader)
-	// 2. pop csv data with smallest month value, increment chunk pointer, and load the next data on the chunk
-	// 3. move the just popped data to the writer buffer, when sorted writer buffer is full write to SortedDataPath
+	// 2. pop csv data with smallest sort key value, increment chunk pointer, and load the next data on the chunk
+	// 3. move the just popped data to the writer buffer, when the writer buffer is full write the merged run
 	heap.Init(&h)
-	for len(h) > 0 {
-		// get data with smallest month value
+	for h.Len() > 0 {
+		// get data with smallest sort key value
 		item := heap.Pop(&h).(CsvDataWithIdx)
 		i := item.Idx
 		csvData := item.Data
@@ -103,6 +608,9 @@ func (s Store) mergeSortedChunks(chunkByteOffset []int64) {
 		if readerIdx[i] == (i+1)*chunkDataSize {
 			readerIdx[i] = i * chunkDataSize
 			readCnt := readers[i].ReadTo(readerIdx[i], readerIdx[i]+chunkDataSize-1)
+			if err := readers[i].Err(); err != nil {
+				return fmt.Errorf("failed to read %s: %w", runs[i].File, err)
+			}
 			readerDataLeft[i] = readCnt
 		}
 		if readerDataLeft[i] > 0 {
@@ -114,33 +622,46 @@ func (s Store) mergeSortedChunks(chunkByteOffset []int64) {
 		s.LimitedSlice.Set(writerIdx, csvData)
 		writerIdx += 1
 		if writerIdx == s.LimitedSlice.GetLimit() {
-			writer.WriteFrom(numChunks*chunkDataSize, s.LimitedSlice.GetLimit()-1)
+			if err := writer.WriteFrom(numChunks*chunkDataSize, s.LimitedSlice.GetLimit()-1); err != nil {
+				return err
+			}
 			writerIdx = numChunks * chunkDataSize
 		}
 	}
 
 	// write the rest of the data, because we only write when writer buffer is full
 	// the previous iteration might not have written yet
-	writer.WriteFrom(numChunks*chunkDataSize, writerIdx-1)
+	return writer.WriteFrom(numChunks*chunkDataSize, writerIdx-1)
 }
 
-// separate each row from the sorted csv into individual columns to `column_store/raw_<column_name>`
-func (s Store) separateColumns() {
-	// intialize bianry writers for each column
-	cols := 10
+// separate each row from the sorted rows into individual columns to `column_store/<rawPrefix><column_name>`, for
+// dictionary encoded columns the distinct values are collected and added to their dictionary afterwards
+func (s Store) separateColumns(rawPrefix string) error {
+	// intialize bianry writers for each column, and sets of distinct values for dictionary encoded columns, we assume
+	// dictionaries are much smaller than the data, like indexes, so we store them directly in memory
+	cols := len(s.ColumnStoreMetadata)
 	writerIdx := []int{}
 	writers := []custom.Writer{}
+	distinctValues := make([]map[string]bool, cols)
 	colDataSize := s.LimitedSlice.GetLimit() / (cols + 1)
-	for i := range cols {
-		writers = append(writers, custom.NewWriter(fmt.Sprintf("column_store/raw_%s", s.ColumnStoreMetadata[i].Name), s.LimitedSlice, custom.ToBinary))
+	for i, metadata := range s.ColumnStoreMetadata {
+		rawPath := filepath.Join(s.ColumnStoreDir, rawPrefix+metadata.Name)
+		writer := custom.NewWriter(rawPath, s.LimitedSlice, custom.ToBinary)
+		if writer == nil {
+			return fmt.Errorf("failed to open %s", rawPath)
+		}
+		writers = append(writers, writer)
 		writerIdx = append(writerIdx, i*colDataSize)
+		if metadata.DictionaryEncode {
+			distinctValues[i] = map[string]bool{}
+		}
 	}
 
 	// initialize reader to read from SortedDataPath
 	readerIdx := cols * colDataSize
-	reader := custom.NewReader(s.SortedDataPath, 0, -1, s.LimitedSlice, custom.FromCsv)
+	reader := custom.NewRowReader(s.SortedDataPath, 0, -1, s.LimitedSlice, s.Schema)
 	for {
-		// load data from sorted csv file 2000 rows at a time
+		// load data from sorted row file 2000 rows at a time
 		readCnt := reader.ReadTo(readerIdx, s.LimitedSlice.GetLimit()-1)
 		if readCnt == 0 {
 			break
@@ -148,118 +669,217 @@ func (s Store) separateColumns() {
 		
 		// for each csv row, split the data and write to the respective columns
 		for i := readerIdx; i < readerIdx+readCnt; i++ {
-			dataCols := s.LimitedSlice.Get(i).(data.CsvData).ToCols() // performs dictionary encoding as well
+			dataCols := s.LimitedSlice.Get(i).(data.CsvData)
 			for col := 0; col < cols; col++ {
-				s.LimitedSlice.Set(writerIdx[col], dataCols[col])
+				val := dataCols[col]
+				if val == data.Null {
+					val = s.ColumnStoreMetadata[col].RawNull()
+				} else if distinctValues[col] != nil {
+					distinctValues[col][val.(string)] = true
+				}
+				s.LimitedSlice.Set(writerIdx[col], val)
 				writerIdx[col] += 1
 				if writerIdx[col] == (col+1)*colDataSize {
-					writers[col].WriteFrom(col*colDataSize, (col+1)*colDataSize-1)
+					if err := writers[col].WriteFrom(col*colDataSize, (col+1)*colDataSize-1); err != nil {
+						return fmt.Errorf("failed to write column %s: %w", s.ColumnStoreMetadata[col].Name, err)
+					}
 					writerIdx[col] = col * colDataSize
 				}
 			}
 		}
 	}
+	if err := reader.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.SortedDataPath, err)
+	}
 
 	// write the rest of the data, because we only write when writer buffer is full
 	// the previous iteration might not have written yet
 	for col := 0; col < cols; col++ {
-		writers[col].WriteFrom(col*colDataSize, writerIdx[col]-1)
+		if err := writers[col].WriteFrom(col*colDataSize, writerIdx[col]-1); err != nil {
+			return fmt.Errorf("failed to write column %s: %w", s.ColumnStoreMetadata[col].Name, err)
+		}
+	}
+
+	// build dictionaries, the codes are assigned in sorted order of the values, so the blocks of a column store an
+	// append added values before existing ones to, or that outgrew its code width, are written again with the new codes
+	for col, values := range distinctValues {
+		if values == nil {
+			continue
+		}
+		old := *s.ColumnStoreMetadata[col]
+		remap, err := s.ColumnStoreMetadata[col].AddDictionaryValues(values)
+		if err != nil {
+			return fmt.Errorf("failed to dictionary encode column %s: %w", s.ColumnStoreMetadata[col].Name, err)
+		}
+		if remap != nil {
+			if err := s.remapColumn(s.ColumnStoreMetadata[col], &old, remap); err != nil {
+				return fmt.Errorf("failed to encode column %s with its new codes: %w", s.ColumnStoreMetadata[col].Name, err)
+			}
+		}
 	}
+	return nil
 }
 
-// process each column again, perform RLE and compute indexes, this writes to `column_store/rle_<column_name>`
-func (s Store) processColumns() {
+// write the blocks of a dictionary encoded column again with the new code of every old code in remap, old is the column
+// before its dictionary changed, so blocks are read with the old code width and written with the new one, the blocks
+// are read one at a time and written to new column files with the same segments, which replace the column files of the
+// column once all blocks are written, the indexes of every block are computed again as its codes changed
+func (s Store) remapColumn(metadata *data.Metadata, old *data.Metadata, remap []int) error {
+	metadata.ResetBlockIndexes()
+	blockOffsets := map[string][]int64{} // offsets of the blocks of every column file not read yet
+	writers := map[string]custom.Writer{}
+	blockSize := 250
+	readStart := s.LimitedSlice.GetLimit() - blockSize
+	for blockIdx := range old.NumBlocks {
+		file := old.BlockFile(blockIdx)
+		path := filepath.Join(s.ColumnStoreDir, file)
+		if _, ok := blockOffsets[file]; !ok {
+			offsets, err := custom.BlockOffsets(path, old.Name)
+			if err != nil {
+				return err
+			}
+			blockOffsets[file] = offsets
+			os.Remove(filepath.Join(s.ColumnStoreDir, "remap_"+file)) // left by a failed append
+			if writers[file], err = custom.NewColumnWriter(filepath.Join(s.ColumnStoreDir, "remap_"+file), s.LimitedSlice, metadata); err != nil {
+				return err
+			}
+		}
+		offsets := blockOffsets[file]
+		if len(offsets) == 0 {
+			return &custom.CorruptionError{Column: old.Name, Block: blockIdx, Reason: "block not in the column file"}
+		}
+		limit := int64(-1)
+		if len(offsets) > 1 {
+			limit = offsets[1]
+		}
+		blockOffsets[file] = offsets[1:]
+
+		// runs are expanded to the start of the limited slice, blocks hold at most blockSize rows
+		reader, err := custom.NewColumnReader(path, old, blockIdx, offsets[0], limit, s.LimitedSlice)
+		if err != nil {
+			return err
+		}
+		readCnt := reader.ReadTo(readStart, s.LimitedSlice.GetLimit()-1)
+		if err := reader.Err(); err != nil {
+			return err
+		}
+		row := 0
+		for i := readStart; i < readStart+readCnt; i++ {
+			run, _ := utils.CheckRun(s.LimitedSlice.Get(i))
+			for range run.Length {
+				value := any(data.Null)
+				if !old.IsNull(blockIdx, row) {
+					value = metadata.Code(remap[data.CodeIdx(run.Value)])
+				}
+				s.LimitedSlice.Set(row, value)
+				row += 1
+			}
+		}
+		metadata.StartSegment(file)
+		if err := s.writeBlock(metadata, writers[file], 0, row-1); err != nil {
+			return err
+		}
+	}
+
+	// the column files of delta segments are hard links to those of the published column store, renaming replaces the
+	// links only
+	for file := range writers {
+		if err := os.Rename(filepath.Join(s.ColumnStoreDir, "remap_"+file), filepath.Join(s.ColumnStoreDir, file)); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// compute the indexes of the codes or values of the limited slice from start to end and write them as the next block
+// of the column, NULLs are only recorded in the validity bit map, the column file holds the zero value in their place
+func (s Store) writeBlock(metadata *data.Metadata, writer custom.Writer, start int, end int) error {
+	metadata.InitBlockIndexes(writer.GetByteOffset())
+	for i := start; i <= end; i++ {
+		current := s.LimitedSlice.Get(i)
+		metadata.UpdateBlockIndexes(i-start, current) // for each value, update the indexes in the current block
+		if current == data.Null {
+			current = metadata.Type
+		}
+		s.LimitedSlice.Set(i, current)
+	}
+	if err := writer.WriteFrom(start, end); err != nil {
+		return fmt.Errorf("failed to write block %d of column %s: %w", metadata.NumBlocks-1, metadata.Name, err)
+	}
+	return nil
+}
+
+// process each column again, perform dictionary encoding and compute indexes and validity bit maps, this reads
+// `column_store/<rawPrefix><column_name>` and appends the blocks to `column_store/rle_<column_name>` or, for a delta
+// segment, to a new `column_store/delta<n>_<column_name>`
+func (s Store) processColumns(rawPrefix string, delta bool) error {
 	// process each column at a time
 	for _, metadata := range s.ColumnStoreMetadata {
-		// initialize the appropriate reader based on column type
-		var reader custom.Reader
-		switch metadata.Type.(type) {
-		case int8:
-			reader = custom.NewReader(fmt.Sprintf("column_store/raw_%s", metadata.Name), 0, -1, s.LimitedSlice, custom.FromBinaryInt8)
-		case float64:
-			reader = custom.NewReader(fmt.Sprintf("column_store/raw_%s", metadata.Name), 0, -1, s.LimitedSlice, custom.FromBinaryFloat64)
-		case string:
-			reader = custom.NewReader(fmt.Sprintf("column_store/raw_%s", metadata.Name), 0, -1, s.LimitedSlice, custom.FromBinaryString)
-		default:
-			fmt.Println("unsupported column type")
-			continue
+		// initialize the appropriate reader based on raw column type, dictionary encoded columns are raw strings
+		// which are mapped to codes of the width picked for the column
+		var codes map[string]any
+		readerType := custom.BinaryReaderType(metadata.Type)
+		if metadata.DictionaryEncode {
+			codes = map[string]any{}
+			for code, value := range metadata.Dictionary {
+				codes[value] = metadata.Code(code)
+			}
+			readerType = custom.FromBinaryString
+		}
+		if readerType == -1 {
+			return fmt.Errorf("column %s has unsupported type %T", metadata.Name, metadata.Type)
+		}
+		rawPath := filepath.Join(s.ColumnStoreDir, rawPrefix+metadata.Name)
+		reader := custom.NewReader(rawPath, 0, -1, s.LimitedSlice, readerType)
+		if reader == nil {
+			return fmt.Errorf("failed to open %s", rawPath)
 		}
 
-		// writer to the specified column file
-		writer := custom.NewWriter(fmt.Sprintf("column_store/rle_%s", metadata.Name), s.LimitedSlice, custom.ToBinary)
+		// writer to the column file of the segment
+		file := metadata.MainFile()
+		if delta {
+			file = metadata.NextDeltaFile()
+		}
+		metadata.StartSegment(file)
+		writer, err := custom.NewColumnWriter(filepath.Join(s.ColumnStoreDir, file), s.LimitedSlice, metadata)
+		if err != nil {
+			return err
+		}
 
-		// perform RLE, we have readerIdx which reads data and writerIdx to indicate which part of the buffer to write back to 
-		// file writerIdx and readerIdx both start at 0, writerIdx will reuse the space that readerIdx has already read to ensure
-		// I/O is minimized as much as possible
+		// every block is written on its own with a checksum in the smallest encoding the column allows, which the writer
+		// picks and records next to the offset map, the offset map points to the start of the block
 		// at the same time perform index computation, we asusme indexes are much smaller than the data, in this case indexes
 		// are 1/250th of the raw data (each block is 250 and we index per block) so we store this directly in memory
 		blockSize := 250
-		prevWriterIdx := 0 // accumulate writerIdx for offset map calculation
 		for {
 			readCnt := reader.ReadTo(0, s.LimitedSlice.GetLimit()-1)
 			if readCnt == 0 {
 				break
 			}
-			runIdx := -1
-			writerIdx := 0
-			readerIdx := 0
-			for readerIdx < readCnt {
-				// this is a new block, so initialize fresh indexes
-				if readerIdx%blockSize == 0 {
-					metadata.InitBlockIndexes(int64(prevWriterIdx + writerIdx))
-				}
-				current := s.LimitedSlice.Get(readerIdx)
-				metadata.UpdateBlockIndexes(current) // for each value, update the indexes in the current block
-
-				// if column is to be encoded perform RLE encding, we use negatie values do distinguish between
-				// run length and actual data
-				if metadata.RunLengthEncode {
-					if runIdx == -1 { // no active run, check if there's repeated data, if there is start the run
-						if readerIdx > 0 && current == s.LimitedSlice.Get(readerIdx-1) {
-							s.LimitedSlice.Set(writerIdx-1, -2)
-							runIdx = writerIdx - 1
-						}
-					} else { // active run ongoing, increment if the read value is the same as the run value
-						runLength := s.LimitedSlice.Get(runIdx).(int)
-						_, isInt8 := metadata.Type.(int8)
-						// int8 can ony store up to 128, so check if we need to end the run also end the run
-						// if we reach the end of our 250 blocks
-						if s.LimitedSlice.Get(runIdx+1) != current || (isInt8 && runLength == -128) || readerIdx%blockSize == 0 {
-							s.endEncodingRun(&runIdx, metadata.Type)
-						} else {
-							s.LimitedSlice.Set(runIdx, runLength-1)
-							readerIdx += 1
-							continue
+			for blockStart := 0; blockStart < readCnt; blockStart += blockSize {
+				blockEnd := min(blockStart+blockSize, readCnt) - 1
+				for i := blockStart; i <= blockEnd; i++ {
+					current := s.LimitedSlice.Get(i)
+					if metadata.IsRawNull(current) {
+						s.LimitedSlice.Set(i, data.Null)
+					} else if codes != nil {
+						code, ok := codes[current.(string)]
+						if !ok {
+							return fmt.Errorf("value %q of column %s is not in its dictionary", current, metadata.Name)
 						}
+						s.LimitedSlice.Set(i, code)
 					}
 				}
 
-				// writerIdx might be behind readerIdx so we have to write data to the writerIdx
-				s.LimitedSlice.Set(writerIdx, current)
-				writerIdx += 1
-				readerIdx += 1
+				// the limited slice holds whole blocks so blocks never span 2 reads
+				if err := s.writeBlock(metadata, writer, blockStart, blockEnd); err != nil {
+					return err
+				}
 			}
-
-			// flush data based on writerIdx and end ongoing runs
-			prevWriterIdx += writerIdx
-			s.endEncodingRun(&runIdx, metadata.Type)
-			writer.WriteFrom(0, writerIdx-1)
+		}
+		if err := reader.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", rawPath, err)
 		}
 	}
-}
-
-// end encoding run by setting runIdx to -1 and updating type of run length into the column type
-func (s Store) endEncodingRun(runIdx *int, colType any) {
-	if *runIdx == -1 {
-		return
-	}
-	switch colType.(type) {
-	case int8:
-		s.LimitedSlice.Set(*runIdx, int8(s.LimitedSlice.Get(*runIdx).(int)))
-	case float64:
-		s.LimitedSlice.Set(*runIdx, float64(s.LimitedSlice.Get(*runIdx).(int)))
-	case string:
-		s.LimitedSlice.Set(*runIdx, strconv.Itoa(s.LimitedSlice.Get(*runIdx).(int)))
-	}
-	*runIdx = -1
+	return nil
 }
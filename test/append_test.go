@@ -0,0 +1,319 @@
+package test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/custom"
+	"sc4023/data"
+	"sc4023/query"
+	"sc4023/store"
+	"sc4023/utils"
+)
+
+// test that appending rows in order and out of order gives the same query results as initializing with all rows
+func TestAppendColumnStore(t *testing.T) {
+	// a sample of the rows is split, later months are appended in order and every 5th row of the earlier months is
+	// appended out of order as a delta segment
+	header, rows := readRows(t)
+	var sample, early, late, delta [][]string
+	for i, row := range rows {
+		if i%6 != 0 {
+			continue
+		}
+		sample = append(sample, row)
+		switch {
+		case row[0] >= "2020-01":
+			late = append(late, row)
+		case i%5 == 0:
+			delta = append(delta, row)
+		default:
+			early = append(early, row)
+		}
+	}
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "all.csv"), header, sample)
+	writeCsv(t, filepath.Join(tmp, "early.csv"), header, early)
+	writeCsv(t, filepath.Join(tmp, "late.csv"), header, late)
+	writeCsv(t, filepath.Join(tmp, "delta.csv"), header, delta)
+
+	full := filepath.Join(tmp, "full")
+	appended := filepath.Join(tmp, "appended")
+	buildColumnStore(t, full, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	buildColumnStore(t, appended, filepath.Join(tmp, "early.csv"), data.DefaultSchema())
+	appendColumnStore(t, appended, filepath.Join(tmp, "late.csv"))
+	month := loadCatalog(t, appended).GetColMetadata("month")
+	assert.Equal(t, []string{"rle_month"}, month.SegmentFiles())
+	appendColumnStore(t, appended, filepath.Join(tmp, "delta.csv"))
+	month = loadCatalog(t, appended).GetColMetadata("month")
+	assert.Equal(t, []string{"rle_month", "delta1_month"}, month.SegmentFiles())
+	assert.Equal(t, loadCatalog(t, full).GetColMetadata("month").Dictionary, month.Dictionary)
+
+	for _, town := range []string{"TAMPINES", "BEDOK", "JURONG WEST"} {
+		for _, months := range [][2]string{{"2014-01", "2014-12"}, {"2019-11", "2020-02"}, {"", ""}} {
+			expected := runQuery(t, full, town, months)
+			assert.InDeltaSlice(t, expected, runQuery(t, appended, town, months), 1e-6, "%s in %v", town, months)
+		}
+	}
+}
+
+// test that appends in order after a delta segment continue the main column file, the last row of the main column file
+// is found after the blocks of the delta segment
+func TestAppendAfterDeltaSegment(t *testing.T) {
+	header, rows := readRows(t)
+	// the delta segment holds a row of every earlier month, so its block isn't encoded like the blocks around it
+	var early, delta, late, later [][]string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		switch {
+		case row[0] >= "2021-01":
+			later = append(later, row)
+		case row[0] >= "2020-01":
+			late = append(late, row)
+		case !seen[row[0]]:
+			seen[row[0]] = true
+			delta = append(delta, row)
+		default:
+			early = append(early, row)
+		}
+	}
+	tmp := t.TempDir()
+	for name, rows := range map[string][][]string{"all": rows, "early": early, "delta": delta, "late": late, "later": later} {
+		writeCsv(t, filepath.Join(tmp, name+".csv"), header, rows)
+	}
+
+	full := filepath.Join(tmp, "full")
+	appended := filepath.Join(tmp, "appended")
+	buildColumnStore(t, full, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	buildColumnStore(t, appended, filepath.Join(tmp, "early.csv"), data.DefaultSchema())
+	appendColumnStore(t, appended, filepath.Join(tmp, "delta.csv"))
+	appendColumnStore(t, appended, filepath.Join(tmp, "late.csv"))
+	appendColumnStore(t, appended, filepath.Join(tmp, "later.csv"))
+	month := loadCatalog(t, appended).GetColMetadata("month")
+	assert.Equal(t, []string{"rle_month", "delta1_month"}, month.SegmentFiles())
+	assert.Len(t, month.Segments, 3)
+
+	for _, town := range []string{"TAMPINES", "BEDOK"} {
+		for _, months := range [][2]string{{"2014-01", "2014-12"}, {"2020-01", "2021-12"}, {"", ""}} {
+			expected := runQuery(t, full, town, months)
+			assert.InDeltaSlice(t, expected, runQuery(t, appended, town, months), 1e-6, "%s in %v", town, months)
+		}
+	}
+}
+
+// test that appends of values that sort before the values of the dictionary keep the dictionary sorted, the blocks of
+// the column are written again with the new codes so range filters still work
+func TestAppendOutOfOrderDictionaryValues(t *testing.T) {
+	// the months of 2015 and the rows of ANG MO KIO, the first town, are appended after the other rows
+	header, rows := readRows(t)
+	var sample, early, delta [][]string
+	for i, row := range rows {
+		if i%6 != 0 {
+			continue
+		}
+		sample = append(sample, row)
+		if row[0] >= "2015-01" && row[0] <= "2015-12" || row[1] == "ANG MO KIO" {
+			delta = append(delta, row)
+		} else {
+			early = append(early, row)
+		}
+	}
+	tmp := t.TempDir()
+	for name, rows := range map[string][][]string{"all": sample, "early": early, "delta": delta} {
+		writeCsv(t, filepath.Join(tmp, name+".csv"), header, rows)
+	}
+
+	full := filepath.Join(tmp, "full")
+	appended := filepath.Join(tmp, "appended")
+	buildColumnStore(t, full, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	buildColumnStore(t, appended, filepath.Join(tmp, "early.csv"), data.DefaultSchema())
+	appendColumnStore(t, appended, filepath.Join(tmp, "delta.csv"))
+	for _, col := range []string{"month", "town"} {
+		assert.Equal(t, loadCatalog(t, full).GetColMetadata(col).Dictionary, loadCatalog(t, appended).GetColMetadata(col).Dictionary)
+	}
+
+	for _, town := range []string{"ANG MO KIO", "TAMPINES"} {
+		for _, months := range [][2]string{{"2014-06", "2016-06"}, {"2015-03", "2015-03"}, {"", ""}} {
+			expected := runQuery(t, full, town, months)
+			assert.InDeltaSlice(t, expected, runQuery(t, appended, town, months), 1e-6, "%s in %v", town, months)
+		}
+	}
+}
+
+// test that appends of values that don't fit the code width of a dictionary encoded column widen its codes, from int8
+// to int16 past 128 values and to int32 past 32768 values, and that the blocks already written are read back unchanged
+func TestAppendWidensDictionaryCodes(t *testing.T) {
+	header, rows := readRows(t)
+	tmp := t.TempDir()
+	streets := func(month string, prefix string, n int) [][]string {
+		streetRows := [][]string{}
+		for i := range n {
+			row := slices.Clone(rows[0])
+			row[0], row[4] = month, fmt.Sprintf("%s %05d", prefix, i)
+			streetRows = append(streetRows, row)
+		}
+		return streetRows
+	}
+	steps := []struct {
+		rows     [][]string
+		codeType any
+	}{
+		{streets("2017-01", "STREET", 128), int8(0)},
+		{streets("2017-02", "AVENUE", 1), int16(0)}, // sorts before the existing values too
+		{streets("2017-03", "BOULEVARD", 32640), int32(0)},
+	}
+	dir := filepath.Join(tmp, "column_store")
+	expected := []string{}
+	for i, step := range steps {
+		path := filepath.Join(tmp, fmt.Sprintf("step%d.csv", i))
+		writeCsv(t, path, header, step.rows)
+		if i == 0 {
+			buildColumnStore(t, dir, path, data.DefaultSchema())
+		} else {
+			appendColumnStore(t, dir, path)
+		}
+		for _, row := range step.rows {
+			expected = append(expected, row[4])
+		}
+
+		street := loadCatalog(t, dir).GetColMetadata("street_name")
+		assert.IsType(t, step.codeType, street.Type, "step %d", i)
+		assert.Len(t, street.Dictionary, len(expected), "step %d", i)
+		limitedSlice := custom.InitLimitedSlice(2000)
+		reader, err := custom.NewColumnReader(filepath.Join(dir, street.MainFile()), street, 0, custom.ColumnFileHeaderSize, -1, limitedSlice)
+		assert.NoError(t, err)
+		values := []string{}
+		for readCnt := reader.ReadTo(0, 1999); readCnt > 0; readCnt = reader.ReadTo(0, 1999) {
+			for i := range readCnt {
+				run, _ := utils.CheckRun(limitedSlice.Get(i))
+				for range run.Length {
+					values = append(values, street.Dictionary.Value(data.CodeIdx(run.Value)))
+				}
+			}
+		}
+		assert.NoError(t, reader.Err())
+		slices.Sort(values)
+		assert.Equal(t, slices.Sorted(slices.Values(expected)), values, "step %d", i)
+	}
+}
+
+// test that initialization fails instead of leaving a column store without the column if a column can't be encoded
+func TestInitUnsupportedColumnType(t *testing.T) {
+	header, rows := readRows(t)
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "rows.csv"), header, rows[:100])
+	schema := data.DefaultSchema()
+	metadatas := data.InitColumnStoreMetadata(schema)
+	metadatas.GetColMetadata("resale_price").Type = int64(0)
+	stagingDir, err := store.PrepareStagingDir(filepath.Join(tmp, "column_store"))
+	assert.NoError(t, err)
+	err = newStore(stagingDir, filepath.Join(tmp, "rows.csv"), "", schema, metadatas).InitColumnStore()
+	assert.ErrorContains(t, err, "column resale_price has unsupported type int64")
+}
+
+// read the header and rows of ResalePricesSingapore.csv
+func readRows(t *testing.T) ([]string, [][]string) {
+	file, err := os.Open("../ResalePricesSingapore.csv")
+	if err != nil {
+		t.Fatalf("Failed to open CSV: %v", err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	return rows[0], rows[1:]
+}
+
+func writeCsv(t *testing.T, path string, header []string, rows [][]string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create CSV: %v", err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write(header)
+	writer.WriteAll(rows)
+}
+
+func loadCatalog(t *testing.T, dir string) data.Metadatas {
+	metadatas, err := data.LoadCatalog(filepath.Join(dir, "catalog.json"))
+	if err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+	return metadatas
+}
+
+func buildColumnStore(t *testing.T, dir string, dataPath string, schema *data.Schema) {
+	stagingDir, err := store.PrepareStagingDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to prepare staging directory: %v", err)
+	}
+	columnStore := newStore(stagingDir, dataPath, "", schema, data.InitColumnStoreMetadata(schema))
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Failed to initialize column store: %v", err)
+	}
+	if err := store.Publish(stagingDir, dir); err != nil {
+		t.Fatalf("Failed to publish column store: %v", err)
+	}
+}
+
+func appendColumnStore(t *testing.T, dir string, dataPath string) {
+	catalog, err := data.ReadCatalog(filepath.Join(dir, "catalog.json"))
+	if err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+	storeDir, release, err := store.CheckComplete(dir)
+	if err != nil {
+		t.Fatalf("Failed to find column store: %v", err)
+	}
+	defer release()
+	stagingDir, err := store.PrepareAppendDir(dir, storeDir)
+	if err != nil {
+		t.Fatalf("Failed to prepare staging directory: %v", err)
+	}
+	columnStore := newStore(stagingDir, dataPath, "append_", catalog.Schema, catalog.Columns)
+	if err := columnStore.AppendColumnStore(); err != nil {
+		t.Fatalf("Failed to append to column store: %v", err)
+	}
+	if err := store.Publish(stagingDir, dir); err != nil {
+		t.Fatalf("Failed to publish column store: %v", err)
+	}
+}
+
+func newStore(dir string, dataPath string, prefix string, schema *data.Schema, metadatas data.Metadatas) store.Store {
+	return store.Store{
+		LimitedSlice:        custom.InitLimitedSlice(2000),
+		ColumnStoreDir:      dir,
+		DataPaths:           []string{dataPath},
+		SortedChunkDataPath: filepath.Join(dir, prefix+"sorted_chunk.rows"),
+		SortedDataPath:      filepath.Join(dir, prefix+"sorted.rows"),
+		CatalogPath:         filepath.Join(dir, "catalog.json"),
+		Schema:              schema,
+		ColumnStoreMetadata: metadatas,
+		RejectsPath:         filepath.Join(dir, "rejects.csv"),
+	}
+}
+
+// run the query of a town over a month range on the column store at dir
+func runQuery(t *testing.T, dir string, town string, months [2]string) []float64 {
+	runner := query.QueryRunner{LimitedSlice: custom.InitLimitedSlice(2000), ColumnStoreDir: dir, TaskQueue: make(chan int)}
+	if err := runner.LoadCatalog(filepath.Join(dir, "catalog.json")); err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+	monthFilter, err := runner.NewRangeFilter("month", months[0], months[1])
+	assert.NoError(t, err)
+	townFilter, err := runner.NewExactFilter("town", town)
+	assert.NoError(t, err)
+	if err := runner.InitQueryPlan([]query.Filter{monthFilter, townFilter}, "resale_price", "floor_area_sqm"); err != nil {
+		t.Fatalf("Failed to plan query: %v", err)
+	}
+	results, err := runner.RunQuery()
+	assert.NoError(t, err)
+	return results
+}
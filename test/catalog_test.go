@@ -0,0 +1,43 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/data"
+)
+
+// test that metadata, dictionaries, and indexes written to the catalog are loaded back unchanged
+func TestCatalogRoundTrip(t *testing.T) {
+	metadatas := data.InitColumnStoreMetadata(data.DefaultSchema())
+	month := metadatas.GetColMetadata("month")
+	month.InitBlockIndexes(0)
+	month.UpdateBlockIndexes(0, int8(3))
+	town := metadatas.GetColMetadata("town")
+	town.Dictionary = data.Dictionary{"BEDOK", "BUKIT PANJANG", "CLEMENTI", "CHOA CHU KANG", "HOUGANG", "JURONG WEST", "PASIR RIS", "TAMPINES"}
+	town.InitBlockIndexes(0)
+	town.UpdateBlockIndexes(0, int8(7))
+	area := metadatas.GetColMetadata("floor_area_sqm")
+	area.InitBlockIndexes(0)
+	area.UpdateBlockIndexes(0, float64(80.5))
+
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	if err := data.SaveCatalog(path, data.DefaultSchema(), metadatas); err != nil {
+		t.Fatalf("failed to save catalog: %s", err)
+	}
+	loaded, err := data.LoadCatalog(path)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s", err)
+	}
+
+	assert.Equal(t, metadatas, loaded)
+
+	// dictionaries are kept out of the catalog in their sidecar files
+	b, _ := os.ReadFile(path)
+	assert.NotContains(t, string(b), "TAMPINES")
+	dictionary, err := data.LoadDictionary(filepath.Join(filepath.Dir(path), town.DictionaryFile()))
+	assert.NoError(t, err)
+	assert.Equal(t, town.Dictionary, dictionary)
+}
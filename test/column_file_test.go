@@ -0,0 +1,309 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/custom"
+	"sc4023/data"
+	"sc4023/utils"
+)
+
+// write blocks of values to a column file in a temp dir like processColumns does, the indexes of every block are
+// computed before it is written and NULLs are written as the zero value, returns its path and the block offsets
+func writeBlocks(t *testing.T, column *data.Metadata, blocks [][]any, limitedSlice custom.LimitedSlice) (string, []int64) {
+	path := filepath.Join(t.TempDir(), "rle_"+column.Name)
+	writer, err := custom.NewColumnWriter(path, limitedSlice, column)
+	if err != nil {
+		t.Fatalf("failed to open column: %s", err)
+	}
+	offsets := []int64{}
+	for _, values := range blocks {
+		offsets = append(offsets, writer.GetByteOffset())
+		column.InitBlockIndexes(writer.GetByteOffset())
+		for i, value := range values {
+			column.UpdateBlockIndexes(i, value)
+			if value == data.Null {
+				value = column.Type
+			}
+			limitedSlice.Set(i, value)
+		}
+		if err := writer.WriteFrom(0, len(values)-1); err != nil {
+			t.Fatalf("failed to write block: %s", err)
+		}
+	}
+	return path, offsets
+}
+
+// write a column file of 3 blocks of 250 float64 values to a temp dir, returns its path and the block offsets
+func writeColumnFile(t *testing.T, column *data.Metadata, limitedSlice custom.LimitedSlice) (string, []int64) {
+	blocks := [][]any{}
+	for block := range 3 {
+		values := []any{}
+		for i := range 250 {
+			values = append(values, float64(block*1000+i))
+		}
+		blocks = append(blocks, values)
+	}
+	return writeBlocks(t, column, blocks, limitedSlice)
+}
+
+// test that blocks of a column file are read back unchanged
+func TestColumnFileRoundTrip(t *testing.T) {
+	column := &data.Metadata{Name: "resale_price", Type: float64(0), RunLengthEncode: true}
+	limitedSlice := custom.InitLimitedSlice(2000)
+	path, offsets := writeColumnFile(t, column, limitedSlice)
+
+	reader, err := custom.NewColumnReader(path, column, 1, offsets[1], offsets[2], limitedSlice)
+	assert.NoError(t, err)
+	readCnt := reader.ReadTo(0, 1999)
+	assert.NoError(t, reader.Err())
+	assert.Equal(t, 250, readCnt)
+	assert.Equal(t, float64(1249), limitedSlice.Get(249))
+}
+
+// test that column files that can't be written fail the writer instead of leaving blocks out
+func TestColumnFileWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	column := &data.Metadata{Name: "resale_price", Type: float64(0), RunLengthEncode: true}
+	_, err := custom.NewColumnWriter("/dev/full", custom.InitLimitedSlice(2000), column)
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+}
+
+// test that truncated or modified column files are reported with the corrupted column and block
+func TestColumnFileCorruption(t *testing.T) {
+	column := &data.Metadata{Name: "resale_price", Type: float64(0), RunLengthEncode: true}
+	limitedSlice := custom.InitLimitedSlice(2000)
+
+	// flipped byte in the payload of block 1
+	path, offsets := writeColumnFile(t, column, limitedSlice)
+	b, _ := os.ReadFile(path)
+	b[offsets[1]+100] ^= 0xff
+	os.WriteFile(path, b, 0644)
+	reader, err := custom.NewColumnReader(path, column, 0, offsets[0], -1, limitedSlice)
+	assert.NoError(t, err)
+	reader.ReadTo(0, 1999)
+	var corruption *custom.CorruptionError
+	assert.True(t, errors.As(reader.Err(), &corruption))
+	assert.Equal(t, "resale_price", corruption.Column)
+	assert.Equal(t, 1, corruption.Block)
+
+	// truncated last block
+	os.WriteFile(path, b[:len(b)-10], 0644)
+	reader, _ = custom.NewColumnReader(path, column, 2, offsets[2], -1, limitedSlice)
+	reader.ReadTo(0, 1999)
+	assert.True(t, errors.As(reader.Err(), &corruption))
+	assert.Equal(t, 2, corruption.Block)
+
+	// file of another format version
+	b[len(custom.ColumnFileMagic)] += 1
+	os.WriteFile(path, b, 0644)
+	_, err = custom.NewColumnReader(path, column, 0, offsets[0], -1, limitedSlice)
+	assert.True(t, errors.As(err, &corruption))
+	assert.Equal(t, -1, corruption.Block)
+}
+
+// test that bit packed codes are read back unchanged, with codes spanning bytes and blocks read in small windows
+func TestBitPackedColumnFile(t *testing.T) {
+	for _, width := range []int{0, 3, 5} {
+		column := &data.Metadata{Name: "town", Type: int8(0), DictionaryEncode: true, BitPacked: true}
+		limitedSlice := custom.InitLimitedSlice(2000)
+		blocks := [][]any{}
+		for block := range 3 {
+			values := []any{}
+			for i := range 250 {
+				values = append(values, int8((block+i)%(1<<width)))
+			}
+			blocks = append(blocks, values)
+		}
+		path, _ := writeBlocks(t, column, blocks, limitedSlice)
+		assert.Equal(t, []data.BlockEncoding{data.BlockBitPacked, data.BlockBitPacked, data.BlockBitPacked}, column.BlockEncodings)
+
+		reader, err := custom.NewColumnReader(path, column, 0, custom.ColumnFileHeaderSize, -1, limitedSlice)
+		assert.NoError(t, err)
+		for row := 0; row < 750; row += 7 {
+			readCnt := reader.ReadTo(0, 6)
+			assert.NoError(t, reader.Err())
+			assert.Equal(t, min(7, 750-row), readCnt)
+			for i := range readCnt {
+				block, idx := (row+i)/250, (row+i)%250
+				assert.Equal(t, int8((block+idx)%(1<<width)), limitedSlice.Get(i), "width %d row %d", width, row+i)
+			}
+		}
+		assert.Equal(t, 0, reader.ReadTo(0, 6))
+		assert.NoError(t, reader.Err())
+	}
+}
+
+// test that frame of reference encoded blocks read back the exact values, whatever decimals they have, and the block
+// minimum in place of NULLs
+func TestFrameOfReferenceColumnFile(t *testing.T) {
+	column := &data.Metadata{Name: "resale_price", Type: float64(0), FrameOfReference: true, Nullable: true, ZoneMapIndexFloat64: []data.ZoneMap[float64]{}, ValidityIndex: []data.Validity{}}
+	blocks := [][]any{
+		{float64(450000), float64(388000), data.Null, float64(1250000)},
+		{67.5, 121.25, 73.7, 67.3},
+		{3.14159265358979, -2.5, 1e300},
+		{data.Null, data.Null},
+	}
+	limitedSlice := custom.InitLimitedSlice(2000)
+	path, _ := writeBlocks(t, column, blocks, limitedSlice)
+	assert.Equal(t, []data.BlockEncoding{data.BlockFrameOfReference, data.BlockFrameOfReference, data.BlockPlain, data.BlockFrameOfReference}, column.BlockEncodings)
+
+	reader, err := custom.NewColumnReader(path, column, 0, custom.ColumnFileHeaderSize, -1, limitedSlice)
+	assert.NoError(t, err)
+	readCnt := reader.ReadTo(0, 1999)
+	assert.NoError(t, reader.Err())
+	assert.Equal(t, 13, readCnt)
+	row := 0
+	for blockIdx, values := range blocks {
+		for i, value := range values {
+			if value == data.Null {
+				assert.True(t, column.IsNull(blockIdx, i))
+			} else {
+				assert.Equal(t, value, limitedSlice.Get(row), "block %d row %d", blockIdx, i)
+			}
+			row += 1
+		}
+	}
+}
+
+// test that delta encoded runs of dictionary codes read back unchanged from any block
+func TestDeltaColumnFile(t *testing.T) {
+	column := &data.Metadata{Name: "month", Type: int16(0), DictionaryEncode: true, Delta: true, RunLengthEncode: true, ZoneMapIndexInt16: []data.ZoneMap[int16]{}}
+	blocks := [][]any{
+		{int16(3), int16(3), int16(3), int16(3), int16(300), int16(301)},
+		{int16(301), int16(301), int16(7), int16(1000), int16(1000), int16(1000)},
+	}
+	runs := [][]utils.Run{
+		{{Value: int16(3), Length: 4}, {Value: int16(300), Length: 1}, {Value: int16(301), Length: 1}},
+		{{Value: int16(301), Length: 2}, {Value: int16(7), Length: 1}, {Value: int16(1000), Length: 3}},
+	}
+	limitedSlice := custom.InitLimitedSlice(2000)
+	path, offsets := writeBlocks(t, column, blocks, limitedSlice)
+	assert.Equal(t, data.BlockDelta, column.BlockEncodings[0])
+
+	for blockIdx := range blocks {
+		reader, err := custom.NewColumnReader(path, column, blockIdx, offsets[blockIdx], -1, limitedSlice)
+		assert.NoError(t, err)
+		readCnt := reader.ReadTo(0, len(runs[blockIdx])-1)
+		assert.NoError(t, reader.Err())
+		assert.Equal(t, len(runs[blockIdx]), readCnt)
+		for i, run := range runs[blockIdx] {
+			read, _ := utils.CheckRun(limitedSlice.Get(i))
+			assert.Equal(t, run, read, "block %d run %d", blockIdx, i)
+		}
+	}
+}
+
+// test that every block is written in the smallest encoding the column allows and read back through it
+func TestAdaptiveBlockEncodings(t *testing.T) {
+	column := &data.Metadata{Name: "street_name", Type: "", RunLengthEncode: true, BlockDictionary: true}
+	blocks := [][]any{{}, {}, {}}
+	for i := range 250 {
+		blocks[0] = append(blocks[0], "ANG MO KIO AVE 10")
+		blocks[1] = append(blocks[1], []string{"BEDOK NTH ST 1", "TAMPINES ST 32", "YISHUN RING RD"}[i%3])
+		blocks[2] = append(blocks[2], strconv.Itoa(i))
+	}
+	limitedSlice := custom.InitLimitedSlice(2000)
+	path, _ := writeBlocks(t, column, blocks, limitedSlice)
+	assert.Equal(t, []data.BlockEncoding{data.BlockRunLength, data.BlockDictionary, data.BlockPlain}, column.BlockEncodings)
+
+	reader, err := custom.NewColumnReader(path, column, 0, custom.ColumnFileHeaderSize, -1, limitedSlice)
+	assert.NoError(t, err)
+	row := 0
+	for {
+		readCnt := reader.ReadTo(0, 99)
+		assert.NoError(t, reader.Err())
+		if readCnt == 0 {
+			break
+		}
+		for i := range readCnt {
+			run, _ := utils.CheckRun(limitedSlice.Get(i))
+			for range run.Length {
+				assert.Equal(t, blocks[row/250][row%250], run.Value, "row %d", row)
+				row += 1
+			}
+		}
+	}
+	assert.Equal(t, 750, row)
+}
+
+// test that compressed blocks are smaller and read back unchanged, also from a middle block up to a byte limit, and
+// that corrupted compressed blocks are reported
+func TestCompressedColumnFile(t *testing.T) {
+	for _, compression := range []string{data.CompressionFlate, data.CompressionLZW} {
+		column := &data.Metadata{Name: "resale_price", Type: float64(0), Compression: compression}
+		limitedSlice := custom.InitLimitedSlice(2000)
+		path, offsets := writeColumnFile(t, column, limitedSlice)
+		info, _ := os.Stat(path)
+		assert.Less(t, info.Size(), int64(3*250*8), compression)
+
+		reader, err := custom.NewColumnReader(path, column, 1, offsets[1], offsets[2], limitedSlice)
+		assert.NoError(t, err)
+		readCnt := reader.ReadTo(0, 1999)
+		assert.NoError(t, reader.Err(), compression)
+		assert.Equal(t, 250, readCnt, compression)
+		for i := range readCnt {
+			assert.Equal(t, float64(1000+i), limitedSlice.Get(i), "%s row %d", compression, i)
+		}
+
+		// flipped byte in the compressed payload of block 2
+		b, _ := os.ReadFile(path)
+		b[offsets[2]+10] ^= 0xff
+		os.WriteFile(path, b, 0644)
+		reader, _ = custom.NewColumnReader(path, column, 0, offsets[0], -1, limitedSlice)
+		assert.Equal(t, 500, reader.ReadTo(0, 1999), compression)
+		var corruption *custom.CorruptionError
+		assert.True(t, errors.As(reader.Err(), &corruption), compression)
+		assert.Equal(t, 2, corruption.Block, compression)
+	}
+}
+
+// test that blocks compression doesn't shrink are stored as encoded, so compression never makes a column file larger,
+// and that compressed and uncompressed blocks of a column file are read back unchanged
+func TestIncompressibleBlocks(t *testing.T) {
+	// the middle block holds values that don't repeat any bytes flate or lzw could use
+	blocks := [][]any{}
+	random := uint64(1)
+	for block := range 3 {
+		values := []any{}
+		for i := range 250 {
+			random = random*6364136223846793005 + 1442695040888963407
+			value := float64(block*1000 + i)
+			if block == 1 && !math.IsNaN(math.Float64frombits(random)) {
+				value = math.Float64frombits(random)
+			}
+			values = append(values, value)
+		}
+		blocks = append(blocks, values)
+	}
+	uncompressedPath, _ := writeBlocks(t, &data.Metadata{Name: "resale_price", Type: float64(0)}, blocks, custom.InitLimitedSlice(2000))
+	uncompressed, _ := os.Stat(uncompressedPath)
+	for _, compression := range []string{data.CompressionFlate, data.CompressionLZW} {
+		column := &data.Metadata{Name: "resale_price", Type: float64(0), Compression: compression}
+		limitedSlice := custom.InitLimitedSlice(2000)
+		path, offsets := writeBlocks(t, column, blocks, limitedSlice)
+		info, _ := os.Stat(path)
+		assert.Less(t, info.Size(), uncompressed.Size(), compression)
+		assert.Equal(t, int64(4+250*8+4), offsets[2]-offsets[1], compression)
+		assert.Equal(t, 2, column.CompressedBlocks, compression)
+
+		reader, err := custom.NewColumnReader(path, column, 0, offsets[0], -1, limitedSlice)
+		assert.NoError(t, err)
+		assert.Equal(t, 750, reader.ReadTo(0, 1999), compression)
+		assert.NoError(t, reader.Err(), compression)
+		for block, values := range blocks {
+			for i, value := range values {
+				assert.Equal(t, value, limitedSlice.Get(block*250+i), "%s block %d row %d", compression, block, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/data"
+	"sc4023/utils"
+)
+
+// test that records are read in the dialect with exact byte offsets and malformed records are skipped
+func TestCsvRecordReader(t *testing.T) {
+	content := "# exported 2024-01-01\r\na;'b;c';'d''e'\r\n\r\n'f\r\ng';h;\n'bad'x;i\n# 'unbalanced quote\nj;k;l"
+	reader := utils.NewCsvRecordReader(strings.NewReader(content), data.Dialect{Delimiter: ";", Quote: "'", Comment: "#"})
+
+	record, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b;c", "d'e"}, record)
+	assert.Equal(t, 2, reader.Line())
+	assert.Equal(t, int64(strings.Index(content, "\r\n\r\n")+2), reader.InputOffset())
+
+	record, err = reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"f\ng", "h", ""}, record)
+	assert.Equal(t, 4, reader.Line())
+
+	_, err = reader.Read()
+	var recordErr *utils.CsvRecordError
+	assert.ErrorAs(t, err, &recordErr)
+	assert.Equal(t, 6, recordErr.Line)
+
+	record, err = reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"j", "k", "l"}, record)
+	assert.Equal(t, int64(len(content)), reader.InputOffset())
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+// test that a tsv with comment lines and its columns in another order than the schema, matched by header name, gives
+// the same query results as the csv
+func TestDialectColumnStore(t *testing.T) {
+	header, rows := readRows(t)
+	sample := [][]string{}
+	for i, row := range rows {
+		if i%6 == 0 {
+			sample = append(sample, row)
+		}
+	}
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "all.csv"), header, sample)
+
+	// reverse the columns and add one the schema doesn't have
+	var tsv strings.Builder
+	tsv.WriteString("# resale prices\n")
+	for i, row := range append([][]string{header}, sample...) {
+		fields := []string{"id"}
+		if i > 0 {
+			fields[0] = string(rune('0' + i%10))
+		}
+		for j := len(row) - 1; j >= 0; j-- {
+			fields = append(fields, row[j])
+		}
+		tsv.WriteString(strings.Join(fields, "\t") + "\r\n")
+		if i%100 == 0 {
+			tsv.WriteString("# page break\r\n")
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "all.tsv"), []byte(tsv.String()), 0644); err != nil {
+		t.Fatalf("Failed to write TSV: %v", err)
+	}
+
+	schema := data.DefaultSchema()
+	if err := schema.SetDialect(data.Dialect{Delimiter: "\t", Comment: "#", Header: data.HeaderNames}); err != nil {
+		t.Fatalf("Failed to set dialect: %v", err)
+	}
+	buildColumnStore(t, filepath.Join(tmp, "csv"), filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	buildColumnStore(t, filepath.Join(tmp, "tsv"), filepath.Join(tmp, "all.tsv"), schema)
+
+	for _, town := range []string{"TAMPINES", "BEDOK"} {
+		for _, months := range [][2]string{{"2014-01", "2014-12"}, {"", ""}} {
+			expected := runQuery(t, filepath.Join(tmp, "csv"), town, months)
+			assert.InDeltaSlice(t, expected, runQuery(t, filepath.Join(tmp, "tsv"), town, months), 1e-6, "%s in %v", town, months)
+		}
+	}
+
+	// a header without a column of the schema is refused
+	os.WriteFile(filepath.Join(tmp, "missing.tsv"), []byte("month\ttown\n2017-01\tBEDOK\n"), 0644)
+	_, err := utils.ReadCsvHeader(filepath.Join(tmp, "missing.tsv"), schema)
+	assert.Error(t, err)
+}
@@ -2,14 +2,20 @@ package test
 
 import (
 	"encoding/csv"
+	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"sc4023/custom"
 	"sc4023/data"
+	"sc4023/query"
 )
 
-// tests that all dtaa are represented in the dictionary and that maping through the dictionary and back leads to the same value
+// tests that all data are represented in the dictionaries built during initialization and that maping through the
+// dictionary and back leads to the same value
 func TestDictionaryMapping(t *testing.T) {
 	file, err := os.Open("../ResalePricesSingapore.csv")
 	if err != nil {
@@ -17,9 +23,15 @@ func TestDictionaryMapping(t *testing.T) {
 	}
 	defer file.Close()
 
+	metadatas, err := data.LoadCatalog("../column_store/catalog.json")
+	if err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+
 	reader := csv.NewReader(file)
 	reader.Read()
 
+	schema := data.DefaultSchema()
 	rowIndex := 1
 
 	for {
@@ -29,48 +41,142 @@ func TestDictionaryMapping(t *testing.T) {
 		}
 		rowIndex++
 
-		csvData, _ := data.ParseRow(row, rowIndex)
-
-		monthInt, ok := data.MonthToInt[csvData.Month]
-		if !ok {
-			t.Errorf("Month %s not found in MonthToInt at row %d", csvData.Month, rowIndex)
-		} else {
-			assert.Equal(t, csvData.Month, data.IntToMonth[monthInt], "Month mismatch at row %d", rowIndex)
+		csvData, err := data.ParseRow(row, rowIndex, schema)
+		if err != nil {
+			continue
 		}
 
-		townInt, ok := data.TownToInt[csvData.Town]
-		if !ok {
-			t.Errorf("Town %s not found in TownToInt at row %d", csvData.Town, rowIndex)
-		} else {
-			assert.Equal(t, csvData.Town, data.IntToTown[townInt], "Town mismatch at row %d", rowIndex)
+		for i, metadata := range metadatas {
+			if !metadata.DictionaryEncode {
+				continue
+			}
+			value := csvData[i].(string)
+			code, ok := metadata.Dictionary.Code(value)
+			if !ok {
+				t.Errorf("%s %s not found in dictionary at row %d", metadata.Name, value, rowIndex)
+			} else {
+				assert.Equal(t, value, metadata.Dictionary.Value(code), "%s mismatch at row %d", metadata.Name, rowIndex)
+			}
 		}
+	}
+}
 
-		flatTypeInt, ok := data.FlatTypeToInt[csvData.FlatType]
-		if !ok {
-			t.Errorf("FlatType %s not found in FlatTypeToInt at row %d", csvData.FlatType, rowIndex)
-		} else {
-			assert.Equal(t, csvData.FlatType, data.IntToFlatType[flatTypeInt], "FlatType mismatch at row %d", rowIndex)
-		}
+// tests that dictionary codes follow the sorted order of the values
+func TestDictionaryOrder(t *testing.T) {
+	dictionary, err := data.NewDictionary(map[string]bool{"TAMPINES": true, "BEDOK": true, "YISHUN": true})
+	if err != nil {
+		t.Fatalf("Failed to build dictionary: %v", err)
+	}
+	assert.Equal(t, data.Dictionary{"BEDOK", "TAMPINES", "YISHUN"}, dictionary)
+
+	code, ok := dictionary.Code("TAMPINES")
+	assert.True(t, ok)
+	assert.Equal(t, 1, code)
+	_, ok = dictionary.Code("PUNGGOL")
+	assert.False(t, ok)
+}
+
+// tests that the code width of a column follows the cardinality of its dictionary
+func TestDictionaryCodeWidth(t *testing.T) {
+	metadatas := data.InitColumnStoreMetadata(data.DefaultSchema())
+	month := metadatas.GetColMetadata("month")
 
-		storeyInt, ok := data.StoreyRangeToInt[csvData.StoreyRange]
-		if !ok {
-			t.Errorf("StoreyRange %s not found in StoreyRangeToInt at row %d", csvData.StoreyRange, rowIndex)
-		} else {
-			assert.Equal(t, csvData.StoreyRange, data.IntToStoreyRange[storeyInt], "StoreyRange mismatch at row %d", rowIndex)
+	for _, tc := range []struct {
+		values   int
+		colType  any
+		sizeByte int64
+	}{{128, int8(0), 1}, {129, int16(0), 2}, {32768, int16(0), 2}, {32769, int32(0), 4}} {
+		values := map[string]bool{}
+		for i := range tc.values {
+			values[fmt.Sprintf("%06d", i)] = true
+		}
+		dictionary, err := data.NewDictionary(values)
+		if err != nil {
+			t.Fatalf("Failed to build dictionary: %v", err)
 		}
+		month.SetDictionary(dictionary)
+		assert.IsType(t, tc.colType, month.Type)
+		assert.Equal(t, tc.sizeByte, month.DataSizeByte)
 
-		modelInt, ok := data.FlatModelToInt[csvData.FlatModel]
-		if !ok {
-			t.Errorf("FlatModel %s not found in FlatModelToInt at row %d", csvData.FlatModel, rowIndex)
-		} else {
-			assert.Equal(t, csvData.FlatModel, data.IntToFlatModel[modelInt], "FlatModel mismatch at row %d", rowIndex)
+		// codes and zone maps follow the code width
+		code, err := month.Encode(dictionary[len(dictionary)-1])
+		assert.NoError(t, err)
+		assert.Equal(t, month.Code(tc.values-1), code)
+		month.InitBlockIndexes(0)
+		month.UpdateBlockIndexes(0, code)
+		assert.Equal(t, 1, max(len(month.ZoneMapIndexInt8), len(month.ZoneMapIndexInt16), len(month.ZoneMapIndexInt32)))
+	}
+}
+
+// tests that exact filters on the codes of high cardinality columns find the same rows as the raw csv
+func TestHighCardinalityExactFilter(t *testing.T) {
+	file, err := os.Open("../ResalePricesSingapore.csv")
+	if err != nil {
+		t.Fatalf("Failed to open CSV: %v", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Read()
+	minPrice := map[[2]string]float64{}
+	for {
+		row, err := reader.Read()
+		if err != nil {
+			break
+		}
+		price, err := strconv.ParseFloat(row[9], 64)
+		if err != nil {
+			continue
 		}
+		for _, key := range [][2]string{{"block", row[3]}, {"street_name", row[4]}} {
+			if current, ok := minPrice[key]; !ok || price < current {
+				minPrice[key] = price
+			}
+		}
+	}
 
-		leaseInt, ok := data.LeaseCommenceToInt[csvData.LeaseCommence]
-		if !ok {
-			t.Errorf("LeaseCommence %s not found in LeaseCommenceToInt at row %d", csvData.LeaseCommence, rowIndex)
-		} else {
-			assert.Equal(t, csvData.LeaseCommence, data.IntToLeaseCommence[leaseInt], "LeaseCommence mismatch at row %d", rowIndex)
+	for _, key := range [][2]string{{"block", "736"}, {"street_name", "BEDOK NTH ST 1"}, {"street_name", "TAMPINES ST 32"}} {
+		runner := query.QueryRunner{LimitedSlice: custom.InitLimitedSlice(2000), ColumnStoreDir: "../column_store", TaskQueue: make(chan int)}
+		if err := runner.LoadCatalog("../column_store/catalog.json"); err != nil {
+			t.Fatalf("Failed to load catalog: %v", err)
 		}
+		filter, err := runner.NewExactFilter(key[0], key[1])
+		if err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+		if err := runner.InitQueryPlan([]query.Filter{filter}, "resale_price", ""); err != nil {
+			t.Fatalf("Failed to plan query: %v", err)
+		}
+		results, err := runner.RunQuery()
+		assert.NoError(t, err)
+		assert.NotEqual(t, math.MaxFloat64, results[0], "%s = %s", key[0], key[1])
+		assert.Equal(t, minPrice[key], results[0], "%s = %s", key[0], key[1])
+	}
+}
+
+// tests that range bounds between or outside the values of a dictionary are moved to the codes of the values in range
+func TestDictionaryCodeRange(t *testing.T) {
+	dictionary := data.Dictionary{"2014-01", "2014-03", "2014-05"}
+	for _, tc := range []struct {
+		min, max    string
+		first, last int
+	}{{"2014-01", "2014-05", 0, 2}, {"2014-02", "2014-04", 1, 1}, {"", "2014-03", 0, 1}, {"2014-06", "", 3, 2}, {"", "2013-12", 0, -1}} {
+		first, last := dictionary.CodeRange(tc.min, tc.max)
+		assert.Equal(t, [2]int{tc.first, tc.last}, [2]int{first, last}, "%s to %s", tc.min, tc.max)
 	}
 }
+
+// tests that queries with bounds and values that are not in the dictionaries answer like those with dictionary values
+func TestFiltersOnAbsentValues(t *testing.T) {
+	metadatas, err := data.LoadCatalog("../column_store/catalog.json")
+	if err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+	month := metadatas.GetColMetadata("month").Dictionary
+	last := month[len(month)-1]
+	assert.Equal(t, runQuery(t, "../column_store", "BEDOK", [2]string{"2021-07", last}), runQuery(t, "../column_store", "BEDOK", [2]string{"2021-07", "2030-01"}))
+	assert.Equal(t, runQuery(t, "../column_store", "BEDOK", [2]string{"2021-07", "2021-08"}), runQuery(t, "../column_store", "BEDOK", [2]string{"2021-06-31", "2021-08"}))
+
+	// no rows match, so the minimum is never lowered
+	assert.Equal(t, math.MaxFloat64, runQuery(t, "../column_store", "ATLANTIS", [2]string{"", ""})[0])
+	assert.Equal(t, math.MaxFloat64, runQuery(t, "../column_store", "BEDOK", [2]string{"2030-01", "2030-02"})[0])
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/data"
+	"sc4023/store"
+	"sc4023/utils"
+)
+
+// test that period files given as a glob, some of them gzipped, give the same query results as a single csv and that
+// rows rejected in a gzipped csv are reported with their line in it
+func TestMultipleInputs(t *testing.T) {
+	header, rows := readRows(t)
+	sample := [][]string{}
+	for i, row := range rows {
+		if i%6 == 0 {
+			sample = append(sample, row)
+		}
+	}
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "all.csv"), header, sample)
+
+	// the sample is split into 4 period files, the second and the last are gzipped
+	periods := filepath.Join(tmp, "periods")
+	os.MkdirAll(periods, os.ModePerm)
+	n := len(sample) / 4
+	writeCsv(t, filepath.Join(periods, "2015.csv"), header, sample[:n])
+	writeGzipCsv(t, filepath.Join(periods, "2016.csv.gz"), header, sample[n:2*n], "2017-01,BEDOK\n")
+	writeCsv(t, filepath.Join(periods, "2017.csv"), header, sample[2*n:3*n])
+	writeGzipCsv(t, filepath.Join(periods, "2018.csv.gz"), header, sample[3*n:], "")
+
+	full := filepath.Join(tmp, "full")
+	periodic := filepath.Join(tmp, "periodic")
+	buildColumnStore(t, full, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	stagingDir, err := store.PrepareStagingDir(periodic)
+	if err != nil {
+		t.Fatalf("Failed to prepare staging directory: %v", err)
+	}
+	columnStore := newStore(stagingDir, filepath.Join(periods, "*.csv*"), "", data.DefaultSchema(), data.InitColumnStoreMetadata(data.DefaultSchema()))
+	columnStore.MaxRejectRatio = 0.01
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Failed to initialize column store: %v", err)
+	}
+	if err := store.Publish(stagingDir, periodic); err != nil {
+		t.Fatalf("Failed to publish column store: %v", err)
+	}
+
+	for _, town := range []string{"TAMPINES", "BEDOK"} {
+		for _, months := range [][2]string{{"2014-01", "2014-12"}, {"", ""}} {
+			expected := runQuery(t, full, town, months)
+			assert.InDeltaSlice(t, expected, runQuery(t, periodic, town, months), 1e-6, "%s in %v", town, months)
+		}
+	}
+	rejects := readRejects(t, periodic)
+	assert.Len(t, rejects, 2)
+	assert.Equal(t, []string{filepath.Join(periods, "2016.csv.gz"), "2", data.RejectFieldCount}, rejects[1][:3])
+}
+
+// test that rows streamed from standard input give the same query results as the csv file they come from
+func TestStdinInput(t *testing.T) {
+	header, rows := readRows(t)
+	sample := [][]string{}
+	for i, row := range rows {
+		if i%6 == 0 {
+			sample = append(sample, row)
+		}
+	}
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "all.csv"), header, sample)
+	raw, err := os.ReadFile(filepath.Join(tmp, "all.csv"))
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+
+	full := filepath.Join(tmp, "full")
+	streamed := filepath.Join(tmp, "streamed")
+	buildColumnStore(t, full, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	stagingDir, err := store.PrepareStagingDir(streamed)
+	if err != nil {
+		t.Fatalf("Failed to prepare staging directory: %v", err)
+	}
+	columnStore := newStore(stagingDir, utils.StdinPath, "", data.DefaultSchema(), data.InitColumnStoreMetadata(data.DefaultSchema()))
+	columnStore.Stdin = strings.NewReader(strings.Replace(string(raw), "\n", "\n2017-01,BEDOK\n", 2))
+	columnStore.MaxRejectRatio = 0.01
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Failed to initialize column store: %v", err)
+	}
+	if err := store.Publish(stagingDir, streamed); err != nil {
+		t.Fatalf("Failed to publish column store: %v", err)
+	}
+
+	for _, town := range []string{"TAMPINES", "BEDOK"} {
+		expected := runQuery(t, full, town, [2]string{"", ""})
+		assert.InDeltaSlice(t, expected, runQuery(t, streamed, town, [2]string{"", ""}), 1e-6, town)
+	}
+	rejects := readRejects(t, streamed)
+	assert.Len(t, rejects, 3)
+	assert.Equal(t, []string{utils.StdinPath, "2"}, rejects[1][:2])
+	assert.Equal(t, []string{utils.StdinPath, "4"}, rejects[2][:2])
+
+	// a lone - reads standard input, wherever it is among the flags
+	flags, err := utils.ParseInitFlags([]string{"-sort-workers", "2", "-", "-store", streamed})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{utils.StdinPath}, flags.DataPaths)
+	assert.Equal(t, streamed, flags.StoreDir)
+	_, err = utils.ParseInitFlags([]string{"-", "other.csv"})
+	assert.Error(t, err)
+}
+
+// write the header, the extra lines, and the rows to a gzipped csv
+func writeGzipCsv(t *testing.T, path string, header []string, rows [][]string, extra string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create CSV: %v", err)
+	}
+	defer file.Close()
+	writer := gzip.NewWriter(file)
+	var content strings.Builder
+	content.WriteString(strings.Join(header, ",") + "\n" + extra)
+	for _, row := range rows {
+		content.WriteString(strings.Join(row, ",") + "\n")
+	}
+	if _, err := writer.Write([]byte(content.String())); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/custom"
+	"sc4023/data"
+	"sc4023/utils"
+)
+
+// test that inspect prints the pruning ratio of the bit maps and zone maps of every column, and how many blocks of a
+// compressed column are stored compressed
+func TestPrintStoreLayout(t *testing.T) {
+	// TAMPINES is only in the second block, a filter on it skips 1 of 2 blocks and one on BEDOK none
+	town := &data.Metadata{Name: "town", Type: int8(0), DictionaryEncode: true, RunLengthEncode: true, NumBlocks: 2,
+		Dictionary:     data.Dictionary{"BEDOK", "TAMPINES"},
+		BlockEncodings: []data.BlockEncoding{data.BlockRunLength, data.BlockRunLength},
+		BitMapIndex:    []data.Bitmap{{true, false}, {true, true}}}
+	// the zone maps don't overlap, a filter on either block minimum skips the other block
+	price := &data.Metadata{Name: "resale_price", Type: float64(0), NumBlocks: 2,
+		BlockEncodings:      []data.BlockEncoding{data.BlockPlain, data.BlockPlain},
+		ZoneMapIndexFloat64: []data.ZoneMap[float64]{{Min: 0, Max: 10}, {Min: 20, Max: 30}}}
+	// a block of repeated strings shrinks when compressed, a block of a single short string doesn't
+	street := &data.Metadata{Name: "street_name", Type: "", Compression: data.CompressionFlate}
+	repeated := []any{}
+	for range 250 {
+		repeated = append(repeated, "ANG MO KIO AVE 10")
+	}
+	writeBlocks(t, street, [][]any{repeated, {"A ST"}}, custom.InitLimitedSlice(2000))
+
+	var out bytes.Buffer
+	utils.PrintStoreLayout(&out, t.TempDir(), data.Metadatas{town, price, street})
+	rows := map[string][]string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 8 {
+			rows[fields[0]] = fields
+		}
+	}
+	assert.Equal(t, "0.25", rows["town"][6])
+	assert.Equal(t, "0.50", rows["resale_price"][6])
+	assert.Equal(t, "-", rows["street_name"][6])
+	assert.Equal(t, "plain:2+flate:1", rows["street_name"][3])
+	assert.Equal(t, "run_length:2", rows["town"][3])
+}
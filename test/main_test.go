@@ -0,0 +1,51 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/utils"
+)
+
+// test the exit codes of the subcommands, for usage errors, a missing column store, and failures while running
+func TestExitCodes(t *testing.T) {
+	tmp := t.TempDir()
+	bin := filepath.Join(tmp, "sc4023")
+	if out, err := exec.Command("go", "build", "-o", bin, "..").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build: %s\n%s", err, out)
+	}
+	notDir := filepath.Join(tmp, "file")
+	os.WriteFile(notDir, nil, 0644)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", []string{}, utils.ExitUsage},
+		{"unknown command", []string{"frobnicate"}, utils.ExitUsage},
+		{"help", []string{"-h"}, utils.ExitOk},
+		{"subcommand help", []string{"query", "-h"}, utils.ExitOk},
+		{"unknown flag", []string{"query", "-frobnicate"}, utils.ExitUsage},
+		{"missing store", []string{"query", "-store", filepath.Join(tmp, "column_store"), "-exact", "town=BEDOK"}, utils.ExitNoStore},
+		{"missing store inspect", []string{"inspect", "-store", filepath.Join(tmp, "column_store")}, utils.ExitNoStore},
+		{"bad filter", []string{"query", "-store", "../column_store", "-exact", "frobnicate=1"}, utils.ExitUsage},
+		{"unsaved results", []string{"query", "-store", "../column_store", "-exact", "town=BEDOK", "-out", filepath.Join(notDir, "results.csv")}, utils.ExitFailure},
+		{"query", []string{"query", "-store", "../column_store", "-exact", "town=BEDOK", "-out", filepath.Join(tmp, "results.csv")}, utils.ExitOk},
+	}
+	for _, tt := range tests {
+		err := exec.Command(bin, tt.args...).Run()
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatalf("failed to run %s: %s", tt.name, err)
+		}
+		assert.Equal(t, tt.code, code, tt.name)
+	}
+}
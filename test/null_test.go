@@ -0,0 +1,68 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/data"
+)
+
+// test that empty fields of nullable columns are kept as NULL while other columns still drop the row
+func TestNullParseRow(t *testing.T) {
+	schema := data.DefaultSchema()
+	row := []string{"2017-01", "ANG MO KIO", "2 ROOM", "406", "ANG MO KIO AVE 10", "10 TO 12", "44", "Improved", "1979", ""}
+
+	csvData, err := data.ParseRow(row, 1, schema)
+	assert.NoError(t, err)
+	assert.Equal(t, data.Null, csvData[9])
+	assert.Equal(t, row, csvData.ToRow())
+
+	row[9] = "NaN"
+	_, err = data.ParseRow(row, 2, schema)
+	assert.Equal(t, &data.RowError{Reason: data.RejectInvalidNumber, Detail: "resale_price"}, err)
+
+	row[9], row[6] = "232000", "abc"
+	_, err = data.ParseRow(row, 3, schema)
+	assert.Error(t, err)
+
+	// empty fields of columns that aren't nullable are rejected as missing
+	schema.Columns[6].Nullable = false
+	row[6] = ""
+	_, err = data.ParseRow(row, 4, schema)
+	assert.Equal(t, &data.RowError{Reason: data.RejectMissingValue, Detail: "floor_area_sqm"}, err)
+}
+
+// test that NULLs are recorded in the validity bit map of their block and left out of the other indexes
+func TestNullValidity(t *testing.T) {
+	metadatas := data.InitColumnStoreMetadata(data.DefaultSchema())
+	price := metadatas.GetColMetadata("resale_price")
+
+	// block without NULLs doesn't store a validity bit map
+	price.InitBlockIndexes(0)
+	for row := range 10 {
+		price.UpdateBlockIndexes(row, float64(1000+row))
+	}
+	assert.False(t, price.HasNulls(0))
+
+	// block with NULLs
+	price.InitBlockIndexes(10)
+	for row := range 20 {
+		if row%3 == 2 {
+			price.UpdateBlockIndexes(row, data.Null)
+		} else {
+			price.UpdateBlockIndexes(row, float64(2000+row))
+		}
+	}
+	assert.True(t, price.HasNulls(1))
+	for row := range 20 {
+		assert.Equal(t, row%3 == 2, price.IsNull(1, row), "row %d", row)
+	}
+	assert.Equal(t, data.ZoneMap[float64]{Min: 2000, Max: 2019}, price.ZoneMapIndexFloat64[1])
+
+	// block with only NULLs never qualifies
+	price.InitBlockIndexes(30)
+	price.UpdateBlockIndexes(0, data.Null)
+	skippable, qualified := price.ZoneMapIndexFloat64[2].Check(0, 1e9)
+	assert.True(t, skippable)
+	assert.False(t, qualified)
+}
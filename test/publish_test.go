@@ -0,0 +1,95 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/store"
+)
+
+// test that a column store only replaces the existing one once it is completely built
+func TestPublishColumnStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "column_store")
+	os.MkdirAll(dir, os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "catalog.json"), []byte("old"), 0644)
+	_, _, err := store.CheckComplete(dir)
+	assert.Error(t, err)
+
+	// the staging directory is empty even if a previous initialization was interrupted
+	stagingDir, err := store.PrepareStagingDir(dir)
+	assert.NoError(t, err)
+	os.WriteFile(filepath.Join(stagingDir, "catalog.json"), []byte("new"), 0644)
+	stagingDir, err = store.PrepareStagingDir(dir)
+	assert.NoError(t, err)
+	entries, _ := os.ReadDir(stagingDir)
+	assert.Empty(t, entries)
+
+	// an interrupted initialization leaves the existing column store untouched
+	b, _ := os.ReadFile(filepath.Join(dir, "catalog.json"))
+	assert.Equal(t, "old", string(b))
+
+	os.WriteFile(filepath.Join(stagingDir, "catalog.json"), []byte("new"), 0644)
+	assert.NoError(t, store.Publish(stagingDir, dir))
+	storeDir, release, err := store.CheckComplete(dir)
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, stagingDir, storeDir)
+	b, _ = os.ReadFile(filepath.Join(dir, "catalog.json"))
+	assert.Equal(t, "new", string(b))
+}
+
+// test that queries keep reading the version of the column store they leased while new ones are published, and
+// that a column store is found again after a crash left dir missing
+func TestPublishVersions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "column_store")
+	publish := func(catalog string) string {
+		stagingDir, err := store.PrepareStagingDir(dir)
+		assert.NoError(t, err)
+		os.WriteFile(filepath.Join(stagingDir, "catalog.json"), []byte(catalog), 0644)
+		assert.NoError(t, store.Publish(stagingDir, dir))
+		return stagingDir
+	}
+	first := publish("first")
+	storeDir, release, err := store.CheckComplete(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, first, storeDir)
+
+	// the version a query started with is kept while the query holds its lease, however many are published
+	second := publish("second")
+	third := publish("third")
+	publish("fourth")
+	b, _ := os.ReadFile(filepath.Join(storeDir, "catalog.json"))
+	assert.Equal(t, "first", string(b))
+	_, err = os.Stat(second)
+	assert.True(t, os.IsNotExist(err))
+
+	// once released it is removed by the next publish, the previous version is always kept
+	release()
+	fifth := publish("fifth")
+	_, err = os.Stat(first)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(third)
+	assert.True(t, os.IsNotExist(err))
+
+	// an interrupted initialization leaves a version that was never linked to, it is removed by the next one
+	stagingDir, err := store.PrepareStagingDir(dir)
+	assert.NoError(t, err)
+	os.WriteFile(filepath.Join(stagingDir, store.CompletionMarker), nil, 0644)
+	storeDir, release, err = store.CheckComplete(dir)
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, fifth, storeDir)
+	sixth := publish("sixth")
+	assert.Equal(t, stagingDir, sixth)
+
+	// a missing link is recovered from the latest complete version
+	os.Remove(dir)
+	storeDir, release, err = store.CheckComplete(dir)
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, sixth, storeDir)
+	b, _ = os.ReadFile(filepath.Join(dir, "catalog.json"))
+	assert.Equal(t, "sixth", string(b))
+}
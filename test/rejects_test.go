@@ -0,0 +1,133 @@
+package test
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/custom"
+	"sc4023/data"
+	"sc4023/store"
+)
+
+// test that rejected rows are written to rejects.csv with their line and reason, that the column store has the other
+// rows, and that initialization aborts when more rows than the maximum reject ratio are rejected
+func TestRejectedRows(t *testing.T) {
+	header, rows := readRows(t)
+	sample := [][]string{}
+	for i, row := range rows {
+		if i%6 == 0 {
+			sample = append(sample, row)
+		}
+	}
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "all.csv"), header, sample)
+
+	// every 1000th row is followed by a rejected row, the field count one spans two lines
+	bad := []struct {
+		line   string
+		reason string
+	}{
+		{"2017-01,\"BEDOK\nNORTH\",3 ROOM", data.RejectFieldCount},
+		{"2017-01,BEDOK,3 ROOM,1,A ST,01 TO 03,60,Improved,1980,abc", data.RejectInvalidNumber},
+		{"2017-01,BEDOK,3 ROOM,1,A ST,01 TO 03,60,Improved,1980,NaN", data.RejectInvalidNumber},
+		{"2017-01,\"BEDOK\"x,3 ROOM", data.RejectMalformed},
+	}
+	var raw strings.Builder
+	raw.WriteString(strings.Join(header, ",") + "\n")
+	line := 1
+	expectedLines := []int{}
+	for i, row := range sample {
+		raw.WriteString(strings.Join(row, ",") + "\n")
+		line += 1
+		if i%1000 == 999 {
+			b := bad[len(expectedLines)%len(bad)]
+			raw.WriteString(b.line + "\n")
+			expectedLines = append(expectedLines, line+1)
+			line += strings.Count(b.line, "\n") + 1
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "bad.csv"), []byte(raw.String()), 0644); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+
+	full := filepath.Join(tmp, "full")
+	quarantined := filepath.Join(tmp, "quarantined")
+	buildColumnStore(t, full, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	stagingDir, err := store.PrepareStagingDir(quarantined)
+	if err != nil {
+		t.Fatalf("Failed to prepare staging directory: %v", err)
+	}
+	columnStore := newStore(stagingDir, filepath.Join(tmp, "bad.csv"), "", data.DefaultSchema(), data.InitColumnStoreMetadata(data.DefaultSchema()))
+	columnStore.MaxRejectRatio = 0.01
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Failed to initialize column store: %v", err)
+	}
+	if err := store.Publish(stagingDir, quarantined); err != nil {
+		t.Fatalf("Failed to publish column store: %v", err)
+	}
+
+	rejects := readRejects(t, quarantined)
+	assert.Len(t, rejects, len(expectedLines)+1)
+	for i, reject := range rejects[1:] {
+		assert.Equal(t, filepath.Join(tmp, "bad.csv"), reject[0])
+		assert.Equal(t, strconv.Itoa(expectedLines[i]), reject[1])
+		assert.Equal(t, bad[i%len(bad)].reason, reject[2])
+	}
+	for _, town := range []string{"TAMPINES", "BEDOK"} {
+		expected := runQuery(t, full, town, [2]string{"", ""})
+		assert.InDeltaSlice(t, expected, runQuery(t, quarantined, town, [2]string{"", ""}), 1e-6, town)
+	}
+
+	// appends add their rejected rows to rejects.csv
+	appendColumnStore(t, quarantined, filepath.Join(tmp, "bad.csv"))
+	assert.Len(t, readRejects(t, quarantined), 2*len(expectedLines)+1)
+
+	// without any rejected rows allowed initialization fails
+	columnStore.MaxRejectRatio = 0
+	os.RemoveAll(stagingDir)
+	assert.Error(t, columnStore.InitColumnStore())
+}
+
+// test that rejected rows that can't be written abort the load instead of being dropped
+func TestRejectWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	header, _ := readRows(t)
+	var raw strings.Builder
+	raw.WriteString(strings.Join(header, ",") + "\n")
+	for range 100 {
+		raw.WriteString("2017-01,BEDOK,3 ROOM,1," + strings.Repeat("A", 1000) + ",01 TO 03,60,Improved,1980,abc\n")
+	}
+	reader, err := custom.NewStreamCsvReader(strings.NewReader(raw.String()), "bad.csv", custom.InitLimitedSlice(2000), data.DefaultSchema())
+	assert.NoError(t, err)
+	rejects, err := custom.NewRejectWriter("/dev/full", "bad.csv")
+	assert.NoError(t, err)
+	reader.Quarantine(rejects, 0)
+
+	assert.Equal(t, 0, reader.ReadTo(0, 1999))
+	assert.ErrorIs(t, reader.Err(), syscall.ENOSPC)
+	assert.Less(t, reader.Rejected(), 100)
+}
+
+// read the rows of rejects.csv of the column store at dir
+func readRejects(t *testing.T, dir string) [][]string {
+	file, err := os.Open(filepath.Join(dir, "rejects.csv"))
+	if err != nil {
+		t.Fatalf("Failed to open rejects: %v", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read rejects: %v", err)
+	}
+	return rows
+}
@@ -3,9 +3,10 @@ package test
 import (
 	"bufio"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sc4023/custom"
 	"sc4023/data"
 	"sc4023/utils"
 	"testing"
@@ -13,56 +14,82 @@ import (
 
 // test that the run length encoded columns can be decoded and is equivalent to the original column data
 func TestRLE(t *testing.T) {
-	metadatas := data.InitColumnStoreMetadata()
+	dir := initKeptStore(t)
+	metadatas, err := data.LoadCatalog(filepath.Join(dir, "catalog.json"))
+	if err != nil {
+		t.Fatalf("failed to load catalog: %s\n", err)
+	}
+	limitedSlice := custom.InitLimitedSlice(2000)
 	for _, metadata := range metadatas {
-		rawPath := fmt.Sprintf("../column_store/raw_%s", metadata.Name)
+		rawPath := filepath.Join(dir, "raw_"+metadata.Name)
 		rawFile, err := os.Open(rawPath)
 		if err != nil {
 			t.Fatalf("failed to open file: %s\n", err)
 		}
 		rawReader := bufio.NewReader(rawFile)
 
-		rlePath := fmt.Sprintf("../column_store/rle_%s", metadata.Name)
-		rleFile, err := os.Open(rlePath)
+		rlePath := filepath.Join(dir, "rle_"+metadata.Name)
+		rleReader, err := custom.NewColumnReader(rlePath, metadata, 0, custom.ColumnFileHeaderSize, -1, limitedSlice)
 		if err != nil {
-			t.Fatalf("failed to open file: %s\n", err)
+			t.Fatalf("failed to open column: %s\n", err)
 		}
-		rleReader := bufio.NewReader(rleFile)
 
 		idx := 0
-		lgth := 0
-		var valRle any
 		for {
-			valRaw, errRaw := read(rawReader, metadata.Type)
-			if errRaw == io.EOF {
+			readCnt := rleReader.ReadTo(0, limitedSlice.GetLimit()-1)
+			if err := rleReader.Err(); err != nil {
+				t.Fatalf("failed to read column: %s\n", err)
+			}
+			if readCnt == 0 {
 				break
 			}
-
-			if lgth > 0 { // active run
-				if valRle != valRaw {
-					t.Fatalf("mismatch of raw value %v and rle value %v in row %v in col %v", valRaw, valRle, idx, metadata.Name)
+			for i := range readCnt {
+				run, _ := utils.CheckRun(limitedSlice.Get(i))
+				for range run.Length {
+					valRaw, err := readRaw(rawReader, metadata)
+					if err != nil {
+						t.Fatalf("rle column %s has more rows than the raw column", metadata.Name)
+					}
+					if run.Value != valRaw {
+						t.Fatalf("mismatch of raw value %v and rle value %v in row %v in col %v", valRaw, run.Value, idx, metadata.Name)
+					}
+					idx += 1
 				}
-				lgth -= 1
-				idx += 1
-				continue
 			}
+		}
+		if _, err := readRaw(rawReader, metadata); err != io.EOF {
+			t.Fatalf("rle column %s has less rows than the raw column", metadata.Name)
+		}
+		rawFile.Close()
+	}
 
-			valRle, _ = read(rleReader, metadata.Type)
-			if lg, isRun := utils.CheckRunLength(valRle); isRun {
-				lgth = lg
-				valRle, _ = read(rleReader, metadata.Type)
-				if valRle != valRaw {
-					t.Fatalf("mismatch of raw value %v and rle value %v in row %v in col %v", valRaw, valRle, idx, metadata.Name)
-				}
-				lgth -= 1
-			} else {
-				if valRle != valRaw {
-					t.Fatalf("mismatch of raw value %v and rle value %v in row %v in col %v", valRaw, valRle, idx, metadata.Name)
-				}
-			}
-			idx += 1
+	// the published column store only has the encoded columns
+	intermediates, _ := filepath.Glob("../column_store/sorted*")
+	raws, _ := filepath.Glob("../column_store/raw_*")
+	if intermediates = append(intermediates, raws...); len(intermediates) > 0 {
+		t.Errorf("published column store has intermediate files %v", intermediates)
+	}
+}
+
+// helper to read raw data, raw data of dictionary encoded columns is converted to its code, NULLs are read as
+// the zero value stored in their place
+func readRaw(reader *bufio.Reader, metadata *data.Metadata) (any, error) {
+	if !metadata.DictionaryEncode {
+		v, err := read(reader, metadata.Type)
+		if err == nil && metadata.IsRawNull(v) {
+			return metadata.Type, nil
 		}
+		return v, err
+	}
+	v, err := read(reader, "")
+	if err != nil {
+		return nil, err
 	}
+	if metadata.IsRawNull(v) {
+		return metadata.Type, nil
+	}
+	code, _ := metadata.Dictionary.Code(v.(string))
+	return metadata.Code(code), nil
 }
 
 // helper to read data and serialize to Go type
@@ -74,16 +101,25 @@ func read(reader *bufio.Reader, colType any) (any, error) {
 		var b byte
 		b, err = reader.ReadByte()
 		v = int8(b)
+	case int16:
+		var n int16
+		err = binary.Read(reader, binary.LittleEndian, &n)
+		v = n
+	case int32:
+		var n int32
+		err = binary.Read(reader, binary.LittleEndian, &n)
+		v = n
 	case float64:
 		var f float64
 		err = binary.Read(reader, binary.LittleEndian, &f)
 		v = f
 	case string:
-		var strBytes []byte
-		strBytes, err = reader.ReadBytes('\n')
-		if err == nil {
-			v = string(strBytes[:len(strBytes)-1])
+		var length uint64
+		if length, err = binary.ReadUvarint(reader); err == nil {
+			strBytes := make([]byte, length)
+			_, err = io.ReadFull(reader, strBytes)
+			v = string(strBytes)
 		}
 	}
 	return v, err
-}
\ No newline at end of file
+}
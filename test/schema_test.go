@@ -0,0 +1,131 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sc4023/data"
+)
+
+// test that schema files declaring unsupported columns are rejected
+func TestSchemaValidation(t *testing.T) {
+	schemas := map[string]string{
+		"unknown type":              `{"columns": [{"name": "a", "type": "int"}]}`,
+		"duplicate column":          `{"columns": [{"name": "a", "type": "string"}, {"name": "a", "type": "string"}]}`,
+		"dictionary on float":       `{"columns": [{"name": "a", "type": "float64", "encodings": ["dictionary"]}]}`,
+		"bit map without encoding":  `{"columns": [{"name": "a", "type": "string", "indexes": ["bit_map"]}]}`,
+		"bit packed strings":        `{"columns": [{"name": "a", "type": "string", "encodings": ["bit_packed"]}]}`,
+		"block dictionary on codes": `{"columns": [{"name": "a", "type": "string", "encodings": ["dictionary", "block_dictionary"]}]}`,
+		"delta without zone map":    `{"columns": [{"name": "a", "type": "string", "encodings": ["dictionary", "delta"]}]}`,
+		"block dictionary on float": `{"columns": [{"name": "a", "type": "float64", "encodings": ["block_dictionary"]}]}`,
+		"unknown compression":       `{"columns": [{"name": "a", "type": "float64", "compression": "gzip"}]}`,
+		"unknown sort key":          `{"columns": [{"name": "a", "type": "string"}], "sort_key": "b"}`,
+		"repeated sort key":         `{"columns": [{"name": "a", "type": "string"}], "sort_key": ["a", "a"]}`,
+		"invalid sort key":          `{"columns": [{"name": "a", "type": "string"}], "sort_key": 1}`,
+		"z-order and sort key":      `{"columns": [{"name": "a", "type": "string"}, {"name": "b", "type": "string"}], "sort_key": "a", "z_order": ["a", "b"]}`,
+		"z-order of 1 column":       `{"columns": [{"name": "a", "type": "string"}], "z_order": ["a"]}`,
+		"quote is delimiter":        `{"columns": [{"name": "a", "type": "string"}], "dialect": {"delimiter": "'", "quote": "'"}}`,
+		"multi-byte delimiter":      `{"columns": [{"name": "a", "type": "string"}], "dialect": {"delimiter": "::"}}`,
+		"unknown header":            `{"columns": [{"name": "a", "type": "string"}], "dialect": {"header": "first"}}`,
+	}
+	for name, schema := range schemas {
+		path := filepath.Join(t.TempDir(), "schema.json")
+		if err := os.WriteFile(path, []byte(schema), 0644); err != nil {
+			t.Fatalf("failed to write schema: %s", err)
+		}
+		_, err := data.LoadSchema(path)
+		assert.Error(t, err, name)
+	}
+}
+
+// test that rows are parsed and ordered according to the schema
+func TestSchemaParseRow(t *testing.T) {
+	schema := data.DefaultSchema()
+	row := []string{"2017-01", "ANG MO KIO", "2 ROOM", "406", "ANG MO KIO AVE 10", "10 TO 12", "44", "Improved", "1979", "232000"}
+
+	csvData, err := data.ParseRow(row, 1, schema)
+	assert.NoError(t, err)
+	assert.Equal(t, data.CsvData{"2017-01", "ANG MO KIO", "2 ROOM", "406", "ANG MO KIO AVE 10", "10 TO 12", float64(44), "Improved", "1979", float64(232000)}, csvData)
+	assert.Equal(t, row, csvData.ToRow())
+
+	earlier := append([]string{"2016-12"}, row[1:]...)
+	earlierData, _ := data.ParseRow(earlier, 2, schema)
+	assert.True(t, schema.Less(earlierData, csvData))
+	assert.False(t, schema.Less(csvData, earlierData))
+
+	_, err = data.ParseRow(row[:9], 3, schema)
+	assert.Error(t, err)
+}
+
+// test that rows are ordered column by column on a composite sort key
+func TestSchemaCompositeSortKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	schemaJSON := `{"columns": [{"name": "a", "type": "string"}, {"name": "b", "type": "float64", "nullable": true}], "sort_key": ["a", "b"]}`
+	if err := os.WriteFile(path, []byte(schemaJSON), 0644); err != nil {
+		t.Fatalf("failed to write schema: %s", err)
+	}
+	schema, err := data.LoadSchema(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data.SortKey{"a", "b"}, schema.SortKey)
+
+	rows := []data.CsvData{{"x", data.Null}, {"x", float64(-1)}, {"x", float64(2)}, {"y", data.Null}, {"y", float64(1)}}
+	for i := range rows {
+		for j := range rows {
+			assert.Equal(t, i < j, schema.Less(rows[i], rows[j]), "%v < %v", rows[i], rows[j])
+		}
+	}
+
+	// only the first column prunes a range of blocks, both keep offset maps for appends to find their last row
+	metadatas := data.InitColumnStoreMetadata(schema)
+	assert.True(t, metadatas.GetColMetadata("a").Sorted)
+	assert.False(t, metadatas.GetColMetadata("b").Sorted)
+	assert.NotNil(t, metadatas.GetColMetadata("b").OffsetMapIndex)
+
+	// a sort key given at initialization replaces the one of the schema
+	assert.NoError(t, schema.SetSortKey([]string{"b"}))
+	assert.False(t, schema.Less(rows[2], rows[4]))
+	assert.Error(t, schema.SetSortKey([]string{"c"}))
+	assert.Equal(t, data.SortKey{"b"}, schema.SortKey)
+}
+
+// test that rows are ordered along the z-order curve over the ranges of the z-order columns
+func TestSchemaZOrder(t *testing.T) {
+	schema := data.DefaultSchema()
+	assert.NoError(t, schema.SetZOrder([]string{"floor_area_sqm", "resale_price"}))
+	assert.Empty(t, schema.SortKey)
+	assert.Error(t, schema.SetZOrder([]string{"floor_area_sqm", "unknown"}))
+
+	// every value gets its own range as there are fewer values than ranges
+	row := data.CsvData{"2017-01", "ANG MO KIO", "2 ROOM", "406", "ANG MO KIO AVE 10", "10 TO 12", data.Null, "Improved", "1979", data.Null}
+	areaCounts, priceCounts := map[uint64]int{}, map[uint64]int{}
+	for v := range 4 {
+		areaCounts[data.ZOrderKey(float64(40+10*v))] = 1
+		priceCounts[data.ZOrderKey(float64(200000+100000*v))] = 1
+	}
+	schema.ZOrderBounds = [][]uint64{data.NewZOrderBounds(areaCounts, 2), data.NewZOrderBounds(priceCounts, 2)}
+	assert.Len(t, schema.ZOrderBounds[0], 3)
+
+	// ranges of (area, price) in z-order, on the same bit the area goes first
+	ranges := [][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 0}, {2, 1}, {3, 0}, {3, 1}, {2, 2}, {2, 3}, {3, 2}, {3, 3}}
+	rows := []data.CsvData{}
+	for _, r := range ranges {
+		zRow := slices.Clone(row)
+		zRow[6], zRow[9] = float64(40+10*r[0]), float64(200000+100000*r[1])
+		rows = append(rows, zRow)
+	}
+	for i := range rows {
+		for j := range rows {
+			assert.Equal(t, i < j, schema.Less(rows[i], rows[j]), "%v < %v", ranges[i], ranges[j])
+		}
+	}
+
+	// NULLs sort first and rows in the same ranges are ordered on the raw values
+	assert.True(t, schema.Less(row, rows[0]))
+	higher := slices.Clone(rows[0])
+	higher[9] = float64(250000)
+	assert.True(t, schema.Less(rows[0], higher))
+	assert.True(t, schema.Less(higher, rows[1]))
+}
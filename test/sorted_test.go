@@ -2,39 +2,41 @@ package test
 
 import (
 	"encoding/csv"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sc4023/custom"
 	"sc4023/data"
+	"sc4023/store"
+	"sc4023/utils"
+	"strings"
+	"syscall"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 // test that the sorted file is actually sorted by month
 func TestFileSortedByMonth(t *testing.T) {
-	file, err := os.Open("../column_store/sorted.csv")
-	if err != nil {
-		t.Fatalf("Error opening file: %s", err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
+	rows := readSortedRows(t, filepath.Join(initKeptStore(t), "sorted.rows"))
 
-	var prevDate string
+	schema := data.DefaultSchema()
+	var prev data.CsvData
 	rowIndex := 0
 
-	for {
-		row, err := reader.Read()
+	for _, row := range rows {
+		current, err := data.ParseRow(row, rowIndex, schema)
 		if err != nil {
-			break
+			t.Fatalf("Error parsing row %d: %s", rowIndex+1, err)
 		}
 
-		csvData, _ := data.ParseRow(row, rowIndex)
-		currentDate := csvData.Month
-
-		if prevDate != "" && data.MonthToInt[currentDate] < data.MonthToInt[prevDate] {
+		if prev != nil && schema.Less(current, prev) {
 			t.Errorf("Error: Date is not sorted at row %d. Previous: %s, Current: %s\n",
-				rowIndex+1, prevDate, currentDate)
+				rowIndex+1, prev[0], current[0])
 		}
 
-		prevDate = currentDate
+		prev = current
 		rowIndex++
 	}
 }
@@ -47,14 +49,8 @@ func TestSortedAndOriginalHaveSameData(t *testing.T) {
 	}
 	defer file1.Close()
 
-	file2, err := os.Open("../column_store/sorted.csv")
-	if err != nil {
-		t.Fatalf("Error opening second file: %s", err)
-	}
-	defer file2.Close()
-
 	rows1 := readAllRows(file1, true)
-	rows2 := readAllRows(file2, false)
+	rows2 := readSortedRows(t, filepath.Join(initKeptStore(t), "sorted.rows"))
 
 	if len(rows1) != len(rows2) {
 		t.Fatalf("Row count mismatch: %d != %d", len(rows1), len(rows2))
@@ -77,6 +73,242 @@ func TestSortedAndOriginalHaveSameData(t *testing.T) {
 	}
 }
 
+// test that the parts sorted by the run generation workers start at the beginning of a record and cover the whole file
+func TestSplitRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.csv")
+	content := "header\na,1\nbb,22\nccc,333\ndddd,4444\n"
+	os.WriteFile(path, []byte(content), 0644)
+
+	bounds, lines, err := utils.SplitRecords(path, 7, 3, data.Dialect{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{7, 17, 25, int64(len(content))}, bounds)
+	assert.Equal(t, []int{0, 2, 3}, lines)
+
+	// more parts than lines leaves parts empty
+	bounds, _, err = utils.SplitRecords(path, 7, 8, data.Dialect{})
+	assert.NoError(t, err)
+	assert.Len(t, bounds, 9)
+	for i, bound := range bounds[1 : len(bounds)-1] {
+		assert.LessOrEqual(t, bounds[i], bound)
+		assert.Equal(t, byte('\n'), content[bound-1], "part %d starts in the middle of a line", i+1)
+	}
+
+	// line breaks in quoted fields don't end a record
+	content = "header\r\n\"a\r\n\"\"b\"\"\n\",1\r\n\"c,\nd\",2\r\n"
+	os.WriteFile(path, []byte(content), 0644)
+	bounds, lines, err = utils.SplitRecords(path, 8, 4, data.Dialect{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{8, 23, 23, int64(len(content)), int64(len(content))}, bounds)
+	assert.Equal(t, []int{0, 3, 3, 5}, lines)
+}
+
+// test that bounds found by resyncing around the split offsets start at records, quoted fields with line breaks are
+// only in some of the parts, so other parts are resynced without reading the records before them
+func TestSplitRecordsResync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.csv")
+	var content strings.Builder
+	content.WriteString("header\n")
+	for i := range 20000 {
+		if i%5000 < 10 {
+			fmt.Fprintf(&content, "\"quoted\nline %d\",%d\n", i, i)
+		} else {
+			fmt.Fprintf(&content, "line %d,%d\n", i, i)
+		}
+	}
+	os.WriteFile(path, []byte(content.String()), 0644)
+
+	// record starts and lines before them from reading every record
+	file, _ := os.Open(path)
+	defer file.Close()
+	reader := utils.NewCsvRecordReader(file, data.Dialect{})
+	starts := map[int64]int{}
+	for {
+		starts[reader.InputOffset()] = reader.Lines()
+		if _, err := reader.Read(); err == io.EOF {
+			break
+		}
+	}
+
+	bounds, lines, err := utils.SplitRecords(path, 7, 7, data.Dialect{})
+	assert.NoError(t, err)
+	assert.Len(t, bounds, 8)
+	for part, bound := range bounds[:7] {
+		before, ok := starts[bound]
+		assert.True(t, ok, "part %d doesn't start at a record", part)
+		assert.Equal(t, before-1, lines[part], "lines before part %d", part)
+	}
+	assert.Equal(t, int64(content.Len()), bounds[7])
+}
+
+// test that rows with quoted fields, escaped quotes, and line breaks, split between the sort workers, are all sorted
+// exactly once
+func TestSortQuotedCsv(t *testing.T) {
+	header, rows := readRows(t)
+	rows = rows[:400]
+	for i, row := range rows {
+		// the rest of a street name after its line break looks like the start of a row
+		row[4] = row[4] + "\n" + strings.Join(row[:4], ",") + ","
+		switch i % 3 {
+		case 1:
+			row[4] = row[4] + " \"EAST\""
+		case 2:
+			row[3] = "\"" + row[3] + "\""
+		}
+	}
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "quoted.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create CSV: %v", err)
+	}
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write(header)
+	writer.WriteAll(rows)
+	file.Close()
+
+	dir := filepath.Join(tmp, "store")
+	os.MkdirAll(dir, os.ModePerm)
+	schema := data.DefaultSchema()
+	columnStore := newStore(dir, path, "", schema, data.InitColumnStoreMetadata(schema))
+	columnStore.KeepIntermediates = true
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Failed to initialize column store: %v", err)
+	}
+	sorted := readSortedRows(t, filepath.Join(dir, "sorted.rows"))
+	assert.Len(t, sorted, len(rows))
+	counts := make(map[string]int)
+	for i := range rows {
+		counts[serializeRow(rows[i])]++
+		counts[serializeRow(sorted[i])]--
+	}
+	for k, v := range counts {
+		if v != 0 {
+			t.Errorf("Mismatch in row: %q => count differs by %d", k, v)
+		}
+	}
+
+	// street names keep their line breaks in the column files
+	street := loadCatalog(t, dir).GetColMetadata("street_name")
+	limitedSlice := custom.InitLimitedSlice(2000)
+	reader, err := custom.NewColumnReader(filepath.Join(dir, street.MainFile()), street, 0, custom.ColumnFileHeaderSize, -1, limitedSlice)
+	assert.NoError(t, err)
+	row := 0
+	for readCnt := reader.ReadTo(0, 1999); readCnt > 0; readCnt = reader.ReadTo(0, 1999) {
+		for i := range readCnt {
+			run, _ := utils.CheckRun(limitedSlice.Get(i))
+			for range run.Length {
+				assert.Equal(t, sorted[row][4], street.Dictionary.Value(data.CodeIdx(run.Value)), "row %d", row)
+				row += 1
+			}
+		}
+	}
+	assert.NoError(t, reader.Err())
+	assert.Equal(t, len(rows), row)
+}
+
+// test that binary rows keep quoted fields, line breaks and NULLs and that their byte offsets are exact
+func TestBinaryRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sorted.rows")
+	schema := data.DefaultSchema()
+	limitedSlice := custom.InitLimitedSlice(4)
+	rows := []data.CsvData{
+		{"2017-01", "ANG MO KIO", "2 ROOM", "406", "ANG MO KIO AVE 10", "10 TO 12", float64(44), "Improved", "1979", float64(232000)},
+		{"2017-01", "BEDOK", "3 ROOM", "\"12\", A", "NEW UPP\r\nCHANGI RD", "01 TO 03", data.Null, "", "1980", float64(250000.5)},
+	}
+	writer := custom.NewWriter(path, limitedSlice, custom.ToRows)
+	limitedSlice.Set(0, rows[0])
+	assert.NoError(t, writer.WriteFrom(0, 0))
+	firstEnd := writer.GetByteOffset()
+	limitedSlice.Set(0, rows[1])
+	assert.NoError(t, writer.WriteFrom(0, 0))
+
+	reader := custom.NewRowReader(path, 0, firstEnd, limitedSlice, schema)
+	assert.Equal(t, 1, reader.ReadTo(0, 3))
+	assert.Equal(t, firstEnd, reader.GetByteOffset())
+	assert.Equal(t, rows[0], limitedSlice.Get(0))
+
+	reader = custom.NewRowReader(path, firstEnd, -1, limitedSlice, schema)
+	assert.Equal(t, 1, reader.ReadTo(0, 3))
+	assert.Equal(t, writer.GetByteOffset(), reader.GetByteOffset())
+	assert.Equal(t, rows[1], limitedSlice.Get(0))
+	assert.NoError(t, reader.Err())
+
+	// a run that ends after the end of the file was truncated, merges fail instead of dropping its rows
+	reader = custom.NewRowReader(path, 0, writer.GetByteOffset()+10, limitedSlice, schema)
+	assert.Equal(t, 2, reader.ReadTo(0, 3))
+	assert.ErrorIs(t, reader.Err(), io.ErrUnexpectedEOF)
+
+	// a run that can't be written fails the sort instead of leaving rows out
+	if _, err := os.Stat("/dev/full"); err == nil {
+		writer = custom.NewWriter("/dev/full", limitedSlice, custom.ToRows)
+		assert.ErrorIs(t, writer.WriteFrom(0, 0), syscall.ENOSPC)
+	}
+}
+
+// test that a merge in several passes, with more chunks than the fan-in, sorts the same data as a single pass
+func TestMultiPassMerge(t *testing.T) {
+	dir := t.TempDir()
+	schema := data.DefaultSchema()
+	columnStore := store.Store{
+		LimitedSlice:        custom.InitLimitedSlice(500), // 3 workers sort 360 chunks, merged 4 at a time they take 5 passes
+		ColumnStoreDir:      dir,
+		DataPaths:           []string{"../ResalePricesSingapore.csv"},
+		SortedChunkDataPath: filepath.Join(dir, "sorted_chunk.rows"),
+		SortedDataPath:      filepath.Join(dir, "sorted.rows"),
+		CatalogPath:         filepath.Join(dir, "catalog.json"),
+		Schema:              schema,
+		ColumnStoreMetadata: data.InitColumnStoreMetadata(schema),
+		MergeFanIn:          4,
+		SortWorkers:         3,
+		KeepIntermediates:   true,
+	}
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Error initializing column store: %s", err)
+	}
+
+	file, err := os.Open("../ResalePricesSingapore.csv")
+	if err != nil {
+		t.Fatalf("Error opening file: %s", err)
+	}
+	defer file.Close()
+	rows1 := readAllRows(file, true)
+	rows2 := readSortedRows(t, filepath.Join(dir, "sorted.rows"))
+	if len(rows1) != len(rows2) {
+		t.Fatalf("Row count mismatch: %d != %d", len(rows1), len(rows2))
+	}
+	counts := make(map[string]int)
+	for i := range rows1 {
+		counts[serializeRow(rows1[i])]++
+		counts[serializeRow(rows2[i])]--
+		if i > 0 && rows2[i][0] < rows2[i-1][0] {
+			t.Errorf("Error: Date is not sorted at row %d. Previous: %s, Current: %s", i+1, rows2[i-1][0], rows2[i][0])
+		}
+	}
+	for k, v := range counts {
+		if v != 0 {
+			t.Errorf("Mismatch in row: %q => count differs by %d", k, v)
+		}
+	}
+
+	// the run files of the merge passes are removed
+	passFiles, _ := filepath.Glob(filepath.Join(dir, "sorted_chunk.rows.pass*"))
+	assert.Empty(t, passFiles)
+}
+
+// initialize a column store of ResalePricesSingapore.csv in a temporary directory, keeping the sorted rows and the raw
+// columns that are removed from published column stores
+func initKeptStore(t *testing.T) string {
+	dir := t.TempDir()
+	schema := data.DefaultSchema()
+	columnStore := newStore(dir, "../ResalePricesSingapore.csv", "", schema, data.InitColumnStoreMetadata(schema))
+	columnStore.KeepIntermediates = true
+	if err := columnStore.InitColumnStore(); err != nil {
+		t.Fatalf("Error initializing column store: %s", err)
+	}
+	return dir
+}
+
 func readAllRows(f *os.File, skipHeader bool) [][]string {
 	reader := csv.NewReader(f)
 	if skipHeader {
@@ -93,6 +325,29 @@ func readAllRows(f *os.File, skipHeader bool) [][]string {
 	return rows
 }
 
+// read the rows of a sorted binary row file as csv rows
+func readSortedRows(t *testing.T, path string) [][]string {
+	limitedSlice := custom.InitLimitedSlice(2000)
+	reader := custom.NewRowReader(path, 0, -1, limitedSlice, data.DefaultSchema())
+	if reader == nil {
+		t.Fatalf("Error opening file: %s", path)
+	}
+	var rows [][]string
+	for {
+		readCnt := reader.ReadTo(0, limitedSlice.GetLimit()-1)
+		if readCnt == 0 {
+			break
+		}
+		for i := range readCnt {
+			rows = append(rows, limitedSlice.Get(i).(data.CsvData).ToRow())
+		}
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatalf("Error reading %s: %s", path, err)
+	}
+	return rows
+}
+
 func serializeRow(row []string) string {
 	out := ""
 	for i, v := range row {
@@ -103,3 +358,42 @@ func serializeRow(row []string) string {
 	}
 	return out
 }
+
+// test that a column store clustered on z-order columns, also after an append, gives the same query results as one
+// sorted on month
+func TestZOrderColumnStore(t *testing.T) {
+	header, rows := readRows(t)
+	var sample, early, late [][]string
+	for i, row := range rows {
+		if i%3 != 0 {
+			continue
+		}
+		sample = append(sample, row)
+		if i%4 == 0 {
+			late = append(late, row)
+		} else {
+			early = append(early, row)
+		}
+	}
+	tmp := t.TempDir()
+	writeCsv(t, filepath.Join(tmp, "all.csv"), header, sample)
+	writeCsv(t, filepath.Join(tmp, "early.csv"), header, early)
+	writeCsv(t, filepath.Join(tmp, "late.csv"), header, late)
+
+	schema := data.DefaultSchema()
+	if err := schema.SetZOrder([]string{"month", "town", "floor_area_sqm"}); err != nil {
+		t.Fatalf("Failed to set z-order: %v", err)
+	}
+	sorted := filepath.Join(tmp, "sorted")
+	clustered := filepath.Join(tmp, "clustered")
+	buildColumnStore(t, sorted, filepath.Join(tmp, "all.csv"), data.DefaultSchema())
+	buildColumnStore(t, clustered, filepath.Join(tmp, "early.csv"), schema)
+	appendColumnStore(t, clustered, filepath.Join(tmp, "late.csv"))
+
+	for _, town := range []string{"TAMPINES", "BEDOK", "JURONG WEST"} {
+		for _, months := range [][2]string{{"2014-01", "2014-12"}, {"2019-11", "2020-02"}, {"", ""}} {
+			expected := runQuery(t, sorted, town, months)
+			assert.InDeltaSlice(t, expected, runQuery(t, clustered, town, months), 1e-6, "%s in %v", town, months)
+		}
+	}
+}
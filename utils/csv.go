@@ -0,0 +1,254 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sc4023/data"
+	"slices"
+	"strings"
+)
+
+// reader of the records of a raw csv written in a dialect, follows RFC 4180 with the delimiter, quote, and comment
+// characters of the dialect, quoted fields may hold delimiters, escaped (doubled) quotes and line breaks, lines may end
+// with \r\n, and empty lines are skipped, tracks the exact byte offset of the consumed input
+type CsvRecordReader struct {
+	reader    *bufio.Reader
+	delimiter byte
+	quote     byte
+	comment   byte
+	offset    int64  // bytes consumed from the start of the reader
+	line      int    // line the last record started on, counted from the start of the reader
+	lines     int    // line breaks consumed
+	field     []byte // field being read, reused for every field
+}
+
+// error of a malformed record, the record is skipped and the next Read starts at the next line
+type CsvRecordError struct {
+	Line int
+	Err  string
+}
+
+func (e *CsvRecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// init new record reader reading r in dialect
+func NewCsvRecordReader(r io.Reader, dialect data.Dialect) *CsvRecordReader {
+	return &CsvRecordReader{
+		reader:    bufio.NewReader(r),
+		delimiter: dialect.DelimiterByte(),
+		quote:     dialect.QuoteByte(),
+		comment:   dialect.CommentByte(),
+	}
+}
+
+// read the next record, returns io.EOF once the input is consumed and a *CsvRecordError for a malformed record
+func (r *CsvRecordReader) Read() ([]string, error) {
+	for {
+		c, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case c == '\n':
+			continue // empty line
+		case c == '\r' && r.peek() == '\n':
+			continue
+		case r.comment != 0 && c == r.comment:
+			r.skipLine()
+			continue
+		}
+		r.unreadByte()
+		r.line = r.lines + 1
+		return r.readRecord()
+	}
+}
+
+// byte offset of the end of the last record, counted from the start of the reader
+func (r *CsvRecordReader) InputOffset() int64 {
+	return r.offset
+}
+
+// line the last record started on, counted from the start of the reader
+func (r *CsvRecordReader) Line() int {
+	return r.line
+}
+
+// number of line breaks consumed, the lines before InputOffset
+func (r *CsvRecordReader) Lines() int {
+	return r.lines
+}
+
+func (r *CsvRecordReader) readRecord() ([]string, error) {
+	record := []string{}
+	for {
+		field, end, err := r.readField()
+		if err != nil {
+			if err != io.EOF {
+				r.skipLine()
+			}
+			return nil, &CsvRecordError{Line: r.line, Err: err.Error()}
+		}
+		record = append(record, field)
+		if end {
+			return record, nil
+		}
+	}
+}
+
+// read a field, returns whether it is the last field of the record
+func (r *CsvRecordReader) readField() (string, bool, error) {
+	r.field = r.field[:0]
+	c, err := r.readByte()
+	if err == io.EOF {
+		return "", true, nil
+	}
+
+	// quoted field, ends at a quote which isn't followed by another quote
+	if c == r.quote {
+		for {
+			c, err := r.readByte()
+			if err == io.EOF {
+				return "", false, errors.New("quoted field is never closed")
+			}
+			if c == '\r' && r.peek() == '\n' {
+				continue // line breaks in quoted fields are read as \n, like encoding/csv does
+			}
+			if c != r.quote {
+				r.field = append(r.field, c)
+				continue
+			}
+			next, err := r.readByte()
+			switch {
+			case err == io.EOF:
+				return string(r.field), true, nil
+			case next == r.quote:
+				r.field = append(r.field, r.quote)
+			case next == r.delimiter:
+				return string(r.field), false, nil
+			case next == '\n':
+				return string(r.field), true, nil
+			case next == '\r' && r.peek() == '\n':
+				r.readByte()
+				return string(r.field), true, nil
+			default:
+				return "", false, fmt.Errorf("unexpected %q after quoted field", next)
+			}
+		}
+	}
+
+	// unquoted field, ends at the delimiter or the end of the line
+	for {
+		switch {
+		case c == r.delimiter:
+			return string(r.field), false, nil
+		case c == '\n':
+			return string(bytes.TrimSuffix(r.field, []byte{'\r'})), true, nil
+		case c == r.quote:
+			return "", false, fmt.Errorf("quote %q in unquoted field", c)
+		}
+		r.field = append(r.field, c)
+		if c, err = r.readByte(); err == io.EOF {
+			return string(r.field), true, nil
+		}
+	}
+}
+
+func (r *CsvRecordReader) readByte() (byte, error) {
+	c, err := r.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.offset += 1
+	if c == '\n' {
+		r.lines += 1
+	}
+	return c, nil
+}
+
+func (r *CsvRecordReader) unreadByte() {
+	r.reader.UnreadByte()
+	r.offset -= 1
+}
+
+// next byte without consuming it, 0 at the end of the input
+func (r *CsvRecordReader) peek() byte {
+	b, err := r.reader.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+// consume the rest of the line
+func (r *CsvRecordReader) skipLine() {
+	for {
+		c, err := r.readByte()
+		if err != nil || c == '\n' {
+			return
+		}
+	}
+}
+
+// where the rows of a raw csv start and which field of a row holds each schema column
+type CsvHeader struct {
+	DataOffset int64 // byte offset of the first row
+	DataLine   int   // number of lines before DataOffset
+	Fields     []int // field of every schema column, nil if the fields are in the order of the schema columns
+	NumFields  int   // number of fields of a row if Fields is set
+}
+
+// read the header of a raw csv in the dialect of the schema, with header names the schema columns are looked up in the
+// header by name and fail if one is missing
+func ReadCsvHeader(filePath string, schema *data.Schema) (CsvHeader, error) {
+	if schema.Dialect.HeaderMode() == data.HeaderNone {
+		return CsvHeader{}, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return CsvHeader{}, err
+	}
+	defer file.Close()
+	input, err := CsvInput(file, filePath, 0)
+	if err != nil {
+		return CsvHeader{}, err
+	}
+	return ReadCsvHeaderFrom(NewCsvRecordReader(input, schema.Dialect), filePath, schema)
+}
+
+// read the header of a raw csv named name from reader, which is at the start of the raw csv and is left at the first
+// row, so the header of an input which can only be read once is read like the header of a file
+func ReadCsvHeaderFrom(reader *CsvRecordReader, name string, schema *data.Schema) (CsvHeader, error) {
+	dialect := schema.Dialect
+	if dialect.HeaderMode() == data.HeaderNone {
+		return CsvHeader{}, nil
+	}
+	names, err := reader.Read()
+	if err == io.EOF {
+		return CsvHeader{}, fmt.Errorf("%s has no header", name)
+	}
+	if err != nil {
+		return CsvHeader{}, fmt.Errorf("invalid header of %s: %w", name, err)
+	}
+	header := CsvHeader{DataOffset: reader.InputOffset(), DataLine: reader.Lines()}
+	if dialect.HeaderMode() != data.HeaderNames {
+		return header, nil
+	}
+
+	if len(names) > 0 {
+		names[0] = strings.TrimPrefix(names[0], "\ufeff") // byte order mark of utf-8 exports
+	}
+	header.NumFields = len(names)
+	for _, col := range schema.Columns {
+		field := slices.Index(names, col.Name)
+		if field == -1 {
+			return CsvHeader{}, fmt.Errorf("header of %s has no column %s", name, col.Name)
+		}
+		header.Fields = append(header.Fields, field)
+	}
+	return header, nil
+}
@@ -1,82 +1,261 @@
 package utils
 
 import (
-	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sc4023/data"
+	"slices"
 	"strings"
+	"sync"
 )
 
-// used to clean column_store directory on each run
-func CleanDir(dir string) error {
-	_, statErr := os.Stat(dir)
-	if os.IsNotExist(statErr) {
-		return nil
-	} else if statErr != nil {
-		return statErr
+// fsync every file in dir and dir itself, so a directory built with buffered writes survives a crash
+func SyncDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
 	}
-	
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Printf("Failed to delete directory: %v\n", err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := SyncFile(filepath.Join(dir, entry.Name())); err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
+	}
+	return SyncFile(dir)
+}
+
+// fsync a file or directory, a directory is synced to persist renames and new files in it
+func SyncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync %s: %w", path, err)
+	}
+	return nil
+}
+
+// data path reading the raw csv from standard input
+const StdinPath = "-"
+
+// expand the globs of the raw csv paths, the matches of a glob are in lexical order, e.g. period files named by date
+// are in date order, StdinPath is kept as is and may only be given once, fails if a path doesn't exist or a glob
+// matches nothing
+func ExpandDataPaths(patterns []string) ([]string, error) {
+	paths := []string{}
+	for _, pattern := range patterns {
+		if pattern == StdinPath {
+			if slices.Contains(paths, StdinPath) {
+				return nil, fmt.Errorf("standard input can only be read once")
+			}
+			paths = append(paths, StdinPath)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("file does not exist: %s", pattern)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil {
+				return nil, fmt.Errorf("error checking file: %w", err)
+			} else if info.IsDir() {
+				return nil, fmt.Errorf("%s is a directory", match)
+			}
 		}
-		return os.Remove(path)
-	})
-	return err
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// whether the raw csv at filePath is gzipped, which is told by its .gz extension
+func IsGzip(filePath string) bool {
+	return strings.HasSuffix(filePath, ".gz")
 }
 
-// count byte width of csv header files, used in column store initialization
-func CountHeaderByte(filePath string) int {
+// input of the raw csv at filePath from byte offset on, file is the opened raw csv at its start, gzipped csvs are
+// decompressed and offset is counted in the decompressed data, which can only be skipped by decompressing it
+func CsvInput(file *os.File, filePath string, offset int64) (io.Reader, error) {
+	if !IsGzip(filePath) {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", filePath, err)
+	}
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", filePath, err)
+	}
+	return reader, nil
+}
+
+// bytes looked at around a bound when resyncing to the start of a record
+const resyncWindow = 64 << 10
+
+// split the csv records of a file from byte offset on into parts of about equal size, returns the byte offset every
+// part starts at followed by the size of the file, and the number of lines between offset and the start of every part,
+// parts start at the beginning of a record and might be empty, see resyncRecord, the lines of the parts are counted in
+// parallel
+func SplitRecords(filePath string, offset int64, parts int, dialect data.Dialect) ([]int64, []int, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		fmt.Printf("Failed to open file: %v\n", err)
+		return nil, nil, err
 	}
 	defer file.Close()
-
-	reader := bufio.NewReader(file)
-	line, err := reader.ReadBytes('\n')
+	info, err := file.Stat()
 	if err != nil {
-		fmt.Printf("Failed to read line: %v\n", err)
+		return nil, nil, err
 	}
 
-	return len(line)
+	size := info.Size()
+	bounds := []int64{offset}
+	for part := 1; part < parts; part++ {
+		target := offset + (size-offset)*int64(part)/int64(parts)
+		bound, ok, err := resyncRecord(file, bounds[part-1], target, size, dialect)
+		if err == nil && !ok {
+			bound, err = scanRecords(file, bounds[part-1], target, size, dialect)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		bounds = append(bounds, bound)
+	}
+	bounds = append(bounds, size)
+
+	// the lines before every part are the line breaks of the parts before it
+	partLines := make([]int, parts)
+	errs := make([]error, parts)
+	var wg sync.WaitGroup
+	for part := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partLines[part], errs[part] = countLines(io.NewSectionReader(file, bounds[part], bounds[part+1]-bounds[part]))
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	lines := []int{0}
+	for part := 1; part < parts; part++ {
+		lines = append(lines, lines[part-1]+partLines[part-1])
+	}
+	return bounds, lines, nil
 }
 
-// save final results to a file
-func SaveResults(matric string, month int8, town int8, area float64, results []float64) {
-	filePath := fmt.Sprintf("results/ScanResult_%s.csv", matric)
+// start of the first line at or after target, prev is the start of a record before it, a line break only ends a record
+// outside quoted fields, which is taken for granted if no quote is found from the bound back to prev or resyncWindow
+// bytes before the bound, returns false if a quote is found or the line is longer than resyncWindow, the records from
+// prev have to be read then
+func resyncRecord(file *os.File, prev int64, target int64, size int64, dialect data.Dialect) (int64, bool, error) {
+	if target <= prev {
+		return prev, true, nil
+	}
+	buf := make([]byte, resyncWindow)
+	n, err := file.ReadAt(buf, target-1)
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	idx := bytes.IndexByte(buf[:n], '\n')
+	if idx == -1 && target-1+int64(n) < size {
+		return 0, false, nil
+	}
+	bound := size
+	if idx != -1 {
+		bound = target + int64(idx)
+	}
+
+	start := max(prev, bound-resyncWindow)
+	n, err = file.ReadAt(buf[:bound-start], start)
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	if bytes.IndexByte(buf[:n], dialect.QuoteByte()) != -1 {
+		return 0, false, nil
+	}
+	return bound, true, nil
+}
+
+// end of the record target is in, read from the start of the record at prev, target itself if a record starts there
+func scanRecords(file *os.File, prev int64, target int64, size int64, dialect data.Dialect) (int64, error) {
+	if _, err := file.Seek(prev, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := NewCsvRecordReader(file, dialect)
+	for prev+reader.InputOffset() < target {
+		_, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, malformed := err.(*CsvRecordError); err != nil && !malformed {
+			return 0, err
+		}
+	}
+	return min(prev+reader.InputOffset(), size), nil
+}
+
+// number of line breaks of the input
+func countLines(input io.Reader) (int, error) {
+	buf := make([]byte, resyncWindow)
+	lines := 0
+	for {
+		n, err := input.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// save final results to a file, each result is a row starting with the values in prefix which describe the query
+func SaveResults(filePath string, header []string, prefix []string, categories []string, results []float64) error {
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		fmt.Printf("failed to create directory %s: %s\n", dir, err)
-		return
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
 	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		fmt.Printf("failed to open file %s: %s\n", filePath, err)
-		return
+		return fmt.Errorf("failed to open file %s: %w", filePath, err)
 	}
+	defer file.Close()
 
 	fmt.Printf("Result:\n")
-	categories := []string{"Minimum Price", "Average Price", "Standard Deviation of Price", "Minimum Price per Square Meter"}
-	for i := 0; i<4; i++ {
+	for i := range results {
 		fmt.Printf("- %s: %.2f\n", categories[i], results[i])
 	}
 
 	writer := csv.NewWriter(file)
-	writer.Write([]string{"Year", "Month", "Town", "Category", "Value"})
-	for i := 0; i<4; i++ {
-		date := strings.Split(data.IntToMonth[month], "-")
-		writer.Write([]string{date[0], date[1], data.IntToTown[town], categories[i], fmt.Sprintf("%.2f", results[i])})
+	writer.Write(append(slices.Clone(header), "Category", "Value"))
+	for i := range results {
+		writer.Write(append(slices.Clone(prefix), categories[i], fmt.Sprintf("%.2f", results[i])))
 	}
 	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write results to %s: %w", filePath, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write results to %s: %w", filePath, err)
+	}
 
-	fmt.Printf("Results saved to: %s", filePath)
+	fmt.Printf("Results saved to: %s\n", filePath)
+	return nil
 }
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sc4023/data"
+	"strings"
+	"text/tabwriter"
+)
+
+// print the layout of the column store, one line per column with its encoding, indexes, pruning ratio and on-disk size
+// including its dictionary
+func PrintStoreLayout(w io.Writer, dir string, metadatas data.Metadatas) {
+	fmt.Fprintf(w, "Column store: %s\n", dir)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tSORTED\tENCODING\tBLOCKS\tINDEXES\tPRUNING\tBYTES")
+	var totalBytes int64
+	for _, m := range metadatas {
+		var fileBytes int64
+		files := m.SegmentFiles()
+		if m.DictionaryEncode {
+			files = append(files, m.DictionaryFile())
+		}
+		for _, file := range files {
+			if info, err := os.Stat(filepath.Join(dir, file)); err == nil {
+				fileBytes += info.Size()
+			}
+		}
+		totalBytes += fileBytes
+		pruning := "-"
+		if ratio, ok := pruningRatio(m); ok {
+			pruning = fmt.Sprintf("%.2f", ratio)
+		}
+		fmt.Fprintf(tw, "%s\t%T\t%t\t%s\t%d\t%s\t%s\t%d\n", m.Name, m.Type, m.Sorted, encodingName(m), m.NumBlocks, strings.Join(indexNames(m), ","), pruning, fileBytes)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Total column bytes: %d\n", totalBytes)
+}
+
+// encodings the blocks of a column are written in with the number of blocks of each, and the compression of the column
+// with the number of blocks stored compressed if any, e.g. run_length:200,plain:40 or bit_packed:240+flate:12
+func encodingName(m *data.Metadata) string {
+	counts := map[data.BlockEncoding]int{}
+	for _, encoding := range m.BlockEncodings {
+		counts[encoding] += 1
+	}
+	names := []string{}
+	for _, encoding := range m.BlockEncodingCandidates() {
+		if counts[encoding] > 0 {
+			names = append(names, fmt.Sprintf("%s:%d", encoding, counts[encoding]))
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	if m.Compression != "" {
+		return fmt.Sprintf("%s+%s:%d", strings.Join(names, ","), m.Compression, m.CompressedBlocks)
+	}
+	return strings.Join(names, ",")
+}
+
+// names of the indexes computed for a column
+func indexNames(m *data.Metadata) []string {
+	names := []string{}
+	if m.ZoneMapIndexInt8 != nil || m.ZoneMapIndexInt16 != nil || m.ZoneMapIndexInt32 != nil || m.ZoneMapIndexFloat64 != nil {
+		names = append(names, "zone_map")
+	}
+	if m.BitMapIndex != nil {
+		names = append(names, "bit_map")
+	}
+	if m.OffsetMapIndex != nil {
+		names = append(names, "offset_map")
+	}
+	if m.ValidityIndex != nil {
+		names = append(names, "validity")
+	}
+	if len(names) == 0 {
+		names = append(names, "-")
+	}
+	return names
+}
+
+// fraction of blocks the indexes of the column let a filter on a single value skip, averaged over the values in the
+// column, the values are the dictionary codes set in the bit maps or else the block minimums of the zone maps, false if
+// the column has neither index
+func pruningRatio(m *data.Metadata) (float64, bool) {
+	switch {
+	case m.BitMapIndex != nil:
+		return bitMapPruningRatio(m.BitMapIndex, len(m.Dictionary)), true
+	case m.ZoneMapIndexInt8 != nil:
+		return zoneMapPruningRatio(m.ZoneMapIndexInt8), true
+	case m.ZoneMapIndexInt16 != nil:
+		return zoneMapPruningRatio(m.ZoneMapIndexInt16), true
+	case m.ZoneMapIndexInt32 != nil:
+		return zoneMapPruningRatio(m.ZoneMapIndexInt32), true
+	case m.ZoneMapIndexFloat64 != nil:
+		return zoneMapPruningRatio(m.ZoneMapIndexFloat64), true
+	}
+	return 0, false
+}
+
+// fraction of blocks an exact filter skips averaged over the codes set in any bit map
+func bitMapPruningRatio(bitMaps []data.Bitmap, codes int) float64 {
+	probes, skipped := 0, 0
+	for code := range codes {
+		blocks := 0
+		for _, bm := range bitMaps {
+			if _, qualified := bm.Check(code); qualified {
+				blocks += 1
+			}
+		}
+		if blocks > 0 {
+			probes += 1
+			skipped += len(bitMaps) - blocks
+		}
+	}
+	if probes == 0 {
+		return 0
+	}
+	return float64(skipped) / float64(probes*len(bitMaps))
+}
+
+// fraction of blocks a range filter on a single value skips averaged over the block minimums, blocks of only NULLs
+// are skipped but not used as a value
+func zoneMapPruningRatio[T data.ZoneMapValue](zoneMaps []data.ZoneMap[T]) float64 {
+	probes, skipped := 0, 0
+	for _, probe := range zoneMaps {
+		if probe.Min > probe.Max {
+			continue
+		}
+		probes += 1
+		for _, zm := range zoneMaps {
+			if _, qualified := zm.Check(probe.Min, probe.Min); !qualified {
+				skipped += 1
+			}
+		}
+	}
+	if probes == 0 {
+		return 0
+	}
+	return float64(skipped) / float64(probes*len(zoneMaps))
+}
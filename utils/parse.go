@@ -3,68 +3,370 @@ package utils
 import (
 	"flag"
 	"fmt"
-	"os"
 	"sc4023/data"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// parse matric to query and raw data path
-func ParseFlags() (int8, int8, float64, string, string) {
-	matric := flag.String("matric", "", "Matriculation number for data query")
-	rawData := flag.String("data", "", "File location of raw data")
-	flag.Parse()
+// exit codes shared by all subcommands
+const (
+	ExitOk      = 0 // subcommand succeeded
+	ExitFailure = 1 // subcommand failed while running
+	ExitUsage   = 2 // invalid subcommand or flags
+	ExitNoStore = 3 // column store is missing or unreadable
+)
+
+// flags of the init subcommand
+type InitFlags struct {
+	DataPaths      []string     // paths of the raw csvs, globs expanded
+	StoreDir       string       // directory to build the column store in
+	Schema         *data.Schema // schema of the raw csv
+	MergeFanIn     int          // maximum number of sorted runs merged at once, 0 for the default
+	SortWorkers    int          // number of goroutines generating sorted runs, 0 for the default
+	MaxRejectRatio float64      // fraction of the rows that may be rejected before initialization aborts
+}
+
+// flags of the append subcommand
+type AppendFlags struct {
+	DataPaths   []string     // paths of the csvs with the rows to append, globs expanded
+	StoreDir    string       // directory of an initialized column store
+	MergeFanIn  int          // maximum number of sorted runs merged at once, 0 for the default
+	SortWorkers int          // number of goroutines generating sorted runs, 0 for the default
+	Dialect     DialectFlags // dialect of the csv, replaces the dialect of the schema of the column store
+}
+
+// csv dialect given with the flags of init and append, only the flags given replace the dialect of the schema
+type DialectFlags struct {
+	dialect data.Dialect
+	given   map[string]bool
+}
+
+// flags of the query subcommand
+type QueryFlags struct {
+	StoreDir     string       // directory of an initialized column store
+	Filters      []FilterFlag // filters rows have to pass
+	AggCol       string       // column to compute the aggregates of
+	PerCol       string       // column to divide AggCol by before computing its minimum, optional
+	ResultPath   string       // path of the results csv
+	ResultHeader []string     // leading columns of the results csv describing the query
+	ResultPrefix []string     // values of the leading columns
+	Categories   []string     // names of the computed results
+}
+
+// filter given on the command line, Max is unused for exact matches and empty
+// Min or Max leave that side of a range unbounded
+type FilterFlag struct {
+	Column string
+	Exact  bool
+	Min    string
+	Max    string
+}
+
+// flags of the inspect subcommand
+type InspectFlags struct {
+	StoreDir string // directory of an initialized column store
+}
+
+// usage of the external sort flags of init and append
+const (
+	fanInUsage       = "Maximum number of sorted runs merged at once by the external sort (default 128)"
+	sortWorkersUsage = "Number of goroutines sorting chunks of the data in parallel (default 4)"
+)
+
+// parse flags of the init subcommand
+func ParseInitFlags(args []string) (InitFlags, error) {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	rawData := fs.String("data", "", "Comma separated file locations or globs of raw data, .gz files are decompressed, - (or a lone -) reads standard input")
+	storeDir := fs.String("store", "./column_store", "Directory to build the column store in")
+	schemaPath := fs.String("schema", "", "Schema file of the raw data (default is the ResalePricesSingapore.csv schema)")
+	sortKey := fs.String("sort-key", "", "Comma separated columns to sort the column store on, e.g. month,town (default is the sort key of the schema)")
+	zOrder := fs.String("z-order", "", "Comma separated columns to cluster the column store on along a z-order curve instead of sorting it, e.g. month,town,floor_area_sqm")
+	fanIn := fs.Int("fan-in", 0, fanInUsage)
+	sortWorkers := fs.Int("sort-workers", 0, sortWorkersUsage)
+	maxRejectRatio := fs.Float64("max-reject-ratio", 1, "Fraction of the rows that may be rejected, written to rejects.csv in the column store, before initialization aborts")
+	dialect := addDialectFlags(fs)
+	if err := parseDataArgs(fs, args, rawData); err != nil {
+		return InitFlags{}, err
+	}
+	if *maxRejectRatio < 0 || *maxRejectRatio > 1 {
+		return InitFlags{}, fmt.Errorf("-max-reject-ratio %g must be between 0 and 1", *maxRejectRatio)
+	}
+	if *sortKey != "" && *zOrder != "" {
+		return InitFlags{}, fmt.Errorf("-sort-key and -z-order can't be used together")
+	}
 
-	// Parse matric number
-	if *matric == "" || len(*matric) < 9 {
-		fmt.Println("Please provide a valid matric number using -matric flag")
-		os.Exit(1)
+	schema := data.DefaultSchema()
+	if *schemaPath != "" {
+		var err error
+		if schema, err = data.LoadSchema(*schemaPath); err != nil {
+			return InitFlags{}, err
+		}
+	}
+	if *sortKey != "" {
+		if err := schema.SetSortKey(strings.Split(*sortKey, ",")); err != nil {
+			return InitFlags{}, fmt.Errorf("invalid -sort-key: %w", err)
+		}
+	}
+	if *zOrder != "" {
+		if err := schema.SetZOrder(strings.Split(*zOrder, ",")); err != nil {
+			return InitFlags{}, fmt.Errorf("invalid -z-order: %w", err)
+		}
+	}
+	dialect.visit(fs)
+	if err := dialect.Apply(schema); err != nil {
+		return InitFlags{}, err
 	}
 
-	year, err := strconv.Atoi(string((*matric)[len(*matric)-2]))
+	dataPaths, err := parseDataPaths(*rawData)
 	if err != nil {
-		fmt.Println("Could not parse year")
-		os.Exit(1)
+		return InitFlags{}, err
+	}
+	return InitFlags{DataPaths: dataPaths, StoreDir: *storeDir, Schema: schema, MergeFanIn: *fanIn, SortWorkers: *sortWorkers,
+		MaxRejectRatio: *maxRejectRatio}, nil
+}
+
+// parse flags of the append subcommand, the rows are parsed with the schema of the column store
+func ParseAppendFlags(args []string) (AppendFlags, error) {
+	fs := flag.NewFlagSet("append", flag.ContinueOnError)
+	rawData := fs.String("data", "", "Comma separated file locations or globs of the rows to append, .gz files are decompressed, - (or a lone -) reads standard input")
+	storeDir := fs.String("store", "./column_store", "Directory of an initialized column store")
+	fanIn := fs.Int("fan-in", 0, fanInUsage)
+	sortWorkers := fs.Int("sort-workers", 0, sortWorkersUsage)
+	dialect := addDialectFlags(fs)
+	if err := parseDataArgs(fs, args, rawData); err != nil {
+		return AppendFlags{}, err
 	}
-	month, err := strconv.Atoi(string((*matric)[len(*matric)-3]))
+	dataPaths, err := parseDataPaths(*rawData)
 	if err != nil {
-		fmt.Println("Could not parse month")
-		os.Exit(1)
+		return AppendFlags{}, err
 	}
-	if year >= 4 && year <= 9 {
-		year += 2010
+	dialect.visit(fs)
+	return AppendFlags{DataPaths: dataPaths, StoreDir: *storeDir, MergeFanIn: *fanIn, SortWorkers: *sortWorkers, Dialect: *dialect}, nil
+}
+
+// add the csv dialect flags of init and append to fs
+func addDialectFlags(fs *flag.FlagSet) *DialectFlags {
+	flags := &DialectFlags{given: map[string]bool{}}
+	fs.StringVar(&flags.dialect.Delimiter, "delimiter", "", "Character between csv fields, tab for tsv (default is the delimiter of the schema, , if none)")
+	fs.StringVar(&flags.dialect.Quote, "quote", "", "Character quoting csv fields (default is the quote of the schema, \" if none)")
+	fs.StringVar(&flags.dialect.Comment, "comment", "", "Lines of the csv starting with this character are skipped, empty for none (default is the comment of the schema)")
+	fs.StringVar(&flags.dialect.Header, "header", "", "Header of the csv, position (fields in schema order), names (fields matched by name), or none (default is the header of the schema, position if none)")
+	return flags
+}
+
+// replace the fields of the dialect of the schema that were given with flags
+func (d DialectFlags) Apply(schema *data.Schema) error {
+	dialect := schema.Dialect
+	for _, f := range []struct {
+		name  string
+		value string
+		field *string
+	}{
+		{"delimiter", d.dialect.Delimiter, &dialect.Delimiter},
+		{"quote", d.dialect.Quote, &dialect.Quote},
+		{"comment", d.dialect.Comment, &dialect.Comment},
+		{"header", d.dialect.Header, &dialect.Header},
+	} {
+		if d.given[f.name] {
+			*f.field = f.value
+		}
+	}
+	if dialect.Delimiter == "tab" || dialect.Delimiter == `\t` {
+		dialect.Delimiter = "\t"
+	}
+	if err := schema.SetDialect(dialect); err != nil {
+		return fmt.Errorf("invalid csv dialect: %w", err)
+	}
+	return nil
+}
+
+// record which dialect flags were given, after fs is parsed
+func (d DialectFlags) visit(fs *flag.FlagSet) {
+	fs.Visit(func(f *flag.Flag) { d.given[f.Name] = true })
+}
+
+// parse the flags of init or append, a lone - before or between the flags reads the raw csv from standard input like
+// -data -, e.g. init - -store ./column_store
+func parseDataArgs(fs *flag.FlagSet, args []string, rawData *string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for fs.NArg() > 0 {
+		if fs.Arg(0) != StdinPath || *rawData != "" {
+			return fmt.Errorf("unexpected argument %s", fs.Arg(0))
+		}
+		*rawData = StdinPath
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check that raw data file locations are given and expand their globs
+func parseDataPaths(rawData string) ([]string, error) {
+	if rawData == "" {
+		return nil, fmt.Errorf("please provide a file path using -data")
+	}
+	return ExpandDataPaths(strings.Split(rawData, ","))
+}
+
+// parse flags of the query subcommand, filters are given with -range and -exact, for ResalePricesSingapore.csv
+// the query can also be derived from a matric number or given with -month, -town and -area
+func ParseQueryFlags(args []string) (QueryFlags, error) {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags := QueryFlags{}
+	fs.Func("range", "Range filter `column=min:max`, min or max may be empty, can be repeated", func(v string) error {
+		column, bounds, ok := strings.Cut(v, "=")
+		min, max, hasSep := strings.Cut(bounds, ":")
+		if !ok || !hasSep || column == "" {
+			return fmt.Errorf("expected column=min:max")
+		}
+		flags.Filters = append(flags.Filters, FilterFlag{Column: column, Min: min, Max: max})
+		return nil
+	})
+	fs.Func("exact", "Exact filter `column=value`, can be repeated", func(v string) error {
+		column, value, ok := strings.Cut(v, "=")
+		if !ok || column == "" {
+			return fmt.Errorf("expected column=value")
+		}
+		flags.Filters = append(flags.Filters, FilterFlag{Column: column, Exact: true, Min: value})
+		return nil
+	})
+	aggCol := fs.String("agg", "resale_price", "Column to compute minimum, average, and standard deviation of")
+	perCol := fs.String("per", "floor_area_sqm", "Column to divide -agg by before computing its minimum, empty to skip")
+	matric := fs.String("matric", "", "Matriculation number to derive the query from")
+	month := fs.String("month", "", "First month (YYYY-MM) of the 2 month query range")
+	town := fs.String("town", "", "Town to query")
+	area := fs.Float64("area", 80, "Minimum floor area in m², used with -matric or -month and -town")
+	storeDir := fs.String("store", "./column_store", "Directory of an initialized column store")
+	out := fs.String("out", "", "Path of the results csv (default results/ScanResult_<matric>.csv)")
+	if err := fs.Parse(args); err != nil {
+		return QueryFlags{}, err
+	}
+	flags.StoreDir, flags.AggCol, flags.PerCol, flags.ResultPath = *storeDir, *aggCol, *perCol, *out
+
+	// matric number query on ResalePricesSingapore.csv
+	if *matric != "" {
+		var err error
+		if *month, *town, err = parseMatric(*matric); err != nil {
+			return QueryFlags{}, err
+		}
+		if flags.ResultPath == "" {
+			flags.ResultPath = fmt.Sprintf("results/ScanResult_%s.csv", *matric)
+		}
+	}
+	if *month != "" || *town != "" {
+		if err := flags.addResalePricesQuery(*month, *town, *area); err != nil {
+			return QueryFlags{}, err
+		}
 	} else {
-		year += 2020
+		descs := []string{}
+		fmt.Printf("Query:\n")
+		for _, filter := range flags.Filters {
+			desc := fmt.Sprintf("%s in [%s, %s]", filter.Column, filter.Min, filter.Max)
+			if filter.Exact {
+				desc = fmt.Sprintf("%s = %s", filter.Column, filter.Min)
+			}
+			fmt.Printf("- %s\n", desc)
+			descs = append(descs, desc)
+		}
+		flags.ResultHeader = []string{"Filter"}
+		flags.ResultPrefix = []string{strings.Join(descs, " and ")}
+		flags.Categories = []string{
+			"Minimum " + flags.AggCol,
+			"Average " + flags.AggCol,
+			"Standard Deviation of " + flags.AggCol,
+			fmt.Sprintf("Minimum %s per %s", flags.AggCol, flags.PerCol),
+		}
 	}
-	if month == 0 {
-		month = 10
+
+	if flags.ResultPath == "" {
+		flags.ResultPath = "results/ScanResult.csv"
 	}
-	monthInt := data.MonthToInt[fmt.Sprintf("%04d-%02d", year, month)]
+	return flags, nil
+}
 
-	townInt, err := strconv.Atoi(string((*matric)[len(*matric)-3]))
+// add filters and result layout of the query on ResalePricesSingapore.csv, which covers 2 months from month
+// of a town and a minimum floor area
+func (flags *QueryFlags) addResalePricesQuery(month string, town string, area float64) error {
+	startMonth, err := time.Parse("2006-01", month)
 	if err != nil {
-		fmt.Println("Could not parse town")
-		os.Exit(1)
+		return fmt.Errorf("please provide a valid month (YYYY-MM) using -month or a matric number using -matric")
 	}
-	town := data.IntToTown[int8(townInt)]
+	if town == "" {
+		return fmt.Errorf("please provide a town using -town or a matric number using -matric")
+	}
+	endMonth := startMonth.AddDate(0, 1, 0).Format("2006-01")
+
+	flags.Filters = append(flags.Filters,
+		FilterFlag{Column: "month", Min: month, Max: endMonth},
+		FilterFlag{Column: "town", Exact: true, Min: town},
+		FilterFlag{Column: "floor_area_sqm", Min: strconv.FormatFloat(area, 'f', -1, 64)},
+	)
+	flags.ResultHeader = []string{"Year", "Month", "Town"}
+	flags.ResultPrefix = []string{month[:4], month[5:], town}
+	flags.Categories = []string{"Minimum Price", "Average Price", "Standard Deviation of Price", "Minimum Price per Square Meter"}
 
 	fmt.Printf("Query:\n")
-	fmt.Printf("- Time range: %s to %s\n", data.IntToMonth[monthInt], data.IntToMonth[monthInt+1])
+	fmt.Printf("- Time range: %s to %s\n", month, endMonth)
 	fmt.Printf("- Town: %s\n", town)
-	fmt.Printf("- Area: ≥ 80m²\n")
+	fmt.Printf("- Area: ≥ %gm²\n", area)
+	return nil
+}
 
-	// Parse raw data file location
-	if *rawData == "" {
-		fmt.Println("Please provide a file path using -data")
-		os.Exit(1)
+// parse flags of the inspect subcommand
+func ParseInspectFlags(args []string) (InspectFlags, error) {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	storeDir := fs.String("store", "./column_store", "Directory of an initialized column store")
+	if err := fs.Parse(args); err != nil {
+		return InspectFlags{}, err
 	}
+	return InspectFlags{StoreDir: *storeDir}, nil
+}
 
-	if _, err := os.Stat(*rawData); os.IsNotExist(err) {
-		fmt.Printf("File does not exist: %s\n", *rawData)
-		os.Exit(1)
-	} else if err != nil {
-		fmt.Printf("Error checking file: %v\n", err)
-		os.Exit(1)
+// parse matric to queried month and town
+func parseMatric(matric string) (string, string, error) {
+	if len(matric) < 9 {
+		return "", "", fmt.Errorf("please provide a valid matric number using -matric")
 	}
 
-	return monthInt, int8(townInt), 80, *rawData, *matric
+	year, err := strconv.Atoi(string(matric[len(matric)-2]))
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse year")
+	}
+	month, err := strconv.Atoi(string(matric[len(matric)-3]))
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse month")
+	}
+	if year >= 4 && year <= 9 {
+		year += 2010
+	} else {
+		year += 2020
+	}
+	if month == 0 {
+		month = 10
+	}
+
+	townInt, err := strconv.Atoi(string(matric[len(matric)-3]))
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse town")
+	}
+
+	return fmt.Sprintf("%04d-%02d", year, month), matricTowns[townInt], nil
+}
+
+// town queried for each digit of a matric number
+var matricTowns = []string{
+	"BEDOK",
+	"BUKIT PANJANG",
+	"CLEMENTI",
+	"CHOA CHU KANG",
+	"HOUGANG",
+	"JURONG WEST",
+	"PASIR RIS",
+	"TAMPINES",
+	"WOODLANDS",
+	"YISHUN",
 }
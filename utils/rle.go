@@ -1,23 +1,16 @@
 package utils
 
-import "strconv"
+// run of Length repeated values in a run length encoded column, runs are stored as (length, value) pairs so every
+// value of the column type can be stored
+type Run struct {
+	Value  any
+	Length int
+}
 
-// check whether a value is data or run length, negative values are run lengths
-func CheckRunLength(v any) (int, bool) {
-	switch v := v.(type) {
-	case int8:
-		if v < 0 {
-			return -int(v), true
-		}
-	case float64:
-		if v < 0 {
-			return -int(v), true
-		}
-	case string:
-		vi, err := strconv.Atoi(v)
-		if err == nil && vi < 0 {
-			return -int(vi), true
-		}
+// check whether a value read from a run length encoded column is a run, single values are returned as runs of 1
+func CheckRun(v any) (Run, bool) {
+	if run, isRun := v.(Run); isRun {
+		return run, true
 	}
-	return -1, false
-}
\ No newline at end of file
+	return Run{Value: v, Length: 1}, false
+}